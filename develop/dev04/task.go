@@ -1,9 +1,20 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
 	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
 /*
@@ -25,41 +36,862 @@ import (
 Программа должна проходить все тесты. Код должен проходить проверки go vet и golint.
 */
 
-func hash(s string) string {
-	h := make([]int, 33)
+var (
+	minSize    int
+	jsonOutput bool
+	lookupWord string
+	letters    string
+	phrases    bool
+	limit      int
+	workers    int
+	indexFile  string
+	saveFile   string
+
+	fileNames []string
+)
+
+var (
+	errorFileNotFound = errors.New("No such file or directory")
+	errorInvalidWord  = errors.New("Word must consist of russian letters only")
+	errorBadTable     = errors.New("Invalid anagram index file")
+	errorMinSize      = errors.New("-min-size must be at least 2, sets have two words or more")
+)
+
+const batchSize = 4096
+
+func init() {
+	flag.IntVar(&minSize, "min-size", 2, "print only sets with at least N words")
+	flag.BoolVar(&jsonOutput, "json", false, "print sets as JSON")
+	flag.StringVar(&lookupWord, "word", "", "print anagrams of the word only")
+	flag.StringVar(&letters, "letters", "", "print words that can be made of the letters")
+	flag.BoolVar(&phrases, "phrases", false, "with -letters print multi-word anagrams using all the letters")
+	flag.IntVar(&limit, "limit", 0, "print at most N results of -letters, 0 means no limit")
+	flag.StringVar(&indexFile, "index", "", "load a saved index instead of reading dictionaries")
+	flag.StringVar(&saveFile, "save", "", "save the index to the file")
+	flag.IntVar(&workers, "workers", runtime.NumCPU(), "number of goroutines hashing the dictionary")
+}
+
+func validWord(s string) bool {
+	if s == "" {
+		return false
+	}
 	for _, r := range s {
+		if (r < 'а' || r > 'я') && r != 'ё' {
+			return false
+		}
+	}
+	return true
+}
+
+// AnagramIndex .
+type AnagramIndex struct {
+	keys  []string
+	sets  map[string][]string
+	bySig map[signature]string
+}
+
+// Len .
+func (idx *AnagramIndex) Len() int {
+	return len(idx.keys)
+}
+
+// Keys returns set keys in the order they were first seen in the dictionary.
+func (idx *AnagramIndex) Keys() []string {
+	keys := make([]string, len(idx.keys))
+	copy(keys, idx.keys)
+	return keys
+}
+
+// Get .
+func (idx *AnagramIndex) Get(key string) ([]string, bool) {
+	set, ok := idx.sets[key]
+	return set, ok
+}
+
+// Lookup returns the set the word belongs to, the word itself need not be in the dictionary.
+func (idx *AnagramIndex) Lookup(word string) ([]string, bool) {
+	word = strings.ToLower(word)
+	if !validWord(word) {
+		return nil, false
+	}
+	sig, ok := newSignature(word)
+	if !ok {
+		return nil, false
+	}
+	key, ok := idx.bySig[sig]
+	if !ok {
+		return nil, false
+	}
+	return idx.sets[key], true
+}
+
+// Range calls fn for every set in key order until fn returns false.
+func (idx *AnagramIndex) Range(fn func(key string, set []string) bool) {
+	for _, key := range idx.keys {
+		if !fn(key, idx.sets[key]) {
+			return
+		}
+	}
+}
+
+// Map .
+func (idx *AnagramIndex) Map() map[string][]string {
+	result := make(map[string][]string, len(idx.keys))
+	for _, key := range idx.keys {
+		result[key] = idx.sets[key]
+	}
+	return result
+}
+
+// String .
+func (idx *AnagramIndex) String() string {
+	builder := strings.Builder{}
+	builder.WriteString("map[")
+	idx.Range(func(key string, set []string) bool {
+		if builder.Len() > len("map[") {
+			builder.WriteString(" ")
+		}
+		builder.WriteString(fmt.Sprintf("%s:%v", key, set))
+		return true
+	})
+	builder.WriteString("]")
+	return builder.String()
+}
+
+// signature counts every letter of a word, 'ё' goes last. It's comparable,
+// so it keys anagram groups.
+type signature [33]byte
+
+// newSignature fails on a letter outside the alphabet and on more than 255
+// copies of a letter, a truncated count would put the word in a wrong group.
+func newSignature(word string) (signature, bool) {
+	var sig signature
+	for _, r := range word {
 		alphPosition := r - 'а'
-		if r == 'ё' {
+		switch {
+		case r == 'ё':
 			alphPosition = 32
+		case r < 'а' || r > 'я':
+			return signature{}, false
 		}
-		h[alphPosition]++
+		if sig[alphPosition] == 255 {
+			return signature{}, false
+		}
+		sig[alphPosition]++
 	}
-	return fmt.Sprint(h)
+	return sig, true
 }
 
-// AnagramSet .
-func AnagramSet(arr []string) *map[string][]string {
-	mapSet := make(map[string][]string)
+// contains reports whether every letter of o is available in s.
+func (s signature) contains(o signature) bool {
+	for i := range s {
+		if o[i] > s[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s signature) sub(o signature) signature {
+	for i := range s {
+		s[i] -= o[i]
+	}
+	return s
+}
+
+func (s signature) empty() bool {
+	return s == signature{}
+}
+
+func (s signature) len() int {
+	var n int
+	for _, c := range s {
+		n += int(c)
+	}
+	return n
+}
+
+type tableEntry struct {
+	sig   signature
+	rank  int
+	key   string
+	words []string
+}
+
+// SignatureTable keeps every group of the dictionary, single words included,
+// sorted by signature so lookups are a binary search.
+type SignatureTable struct {
+	entries []tableEntry
+}
+
+const (
+	tableMagic   = "ANAG"
+	tableVersion = 1
+	// maxTableWord bounds a word length read from a table file
+	maxTableWord = 1 << 16
+)
+
+func (t *SignatureTable) sort() {
+	sort.Slice(t.entries, func(i, j int) bool {
+		return bytes.Compare(t.entries[i].sig[:], t.entries[j].sig[:]) < 0
+	})
+}
+
+// Len .
+func (t *SignatureTable) Len() int {
+	return len(t.entries)
+}
+
+// Anagrams returns dictionary words made of exactly the letters of word.
+func (t *SignatureTable) Anagrams(word string) []string {
+	sig, ok := newSignature(strings.ToLower(word))
+	if !ok {
+		return nil
+	}
+
+	i := sort.Search(len(t.entries), func(i int) bool {
+		return bytes.Compare(t.entries[i].sig[:], sig[:]) >= 0
+	})
+	if i == len(t.entries) || t.entries[i].sig != sig {
+		return nil
+	}
+	return t.entries[i].words
+}
 
+// fitting returns entries that can be made of the letters of sig.
+func (t *SignatureTable) fitting(sig signature) []*tableEntry {
+	var result []*tableEntry
+	for i := range t.entries {
+		// entries are sorted, so once the first letter is short nothing further fits
+		if t.entries[i].sig[0] > sig[0] {
+			break
+		}
+		if sig.contains(t.entries[i].sig) {
+			result = append(result, &t.entries[i])
+		}
+	}
+	return result
+}
+
+// SubAnagrams returns dictionary words that can be made of a subset of letters,
+// longest first. limit <= 0 means no limit.
+func (t *SignatureTable) SubAnagrams(letters string, limit int) []string {
+	sig, ok := newSignature(strings.ToLower(letters))
+	if !ok {
+		return nil
+	}
+
+	var result []string
+	for _, e := range t.fitting(sig) {
+		result = append(result, e.words...)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		li, lj := utf8.RuneCountInString(result[i]), utf8.RuneCountInString(result[j])
+		if li != lj {
+			return li > lj
+		}
+		return result[i] < result[j]
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// Phrases returns multi-word anagrams that use every letter exactly once,
+// phrases starting with longer words come first. limit <= 0 means no limit.
+func (t *SignatureTable) Phrases(letters string, limit int) [][]string {
+	sig, ok := newSignature(strings.ToLower(letters))
+	if !ok || sig.empty() {
+		return nil
+	}
+
+	cands := t.fitting(sig)
+	sort.SliceStable(cands, func(i, j int) bool {
+		return cands[i].sig.len() > cands[j].sig.len()
+	})
+
+	s := phraseSearch{limit: limit}
+	s.search(sig, cands, nil)
+	return s.result
+}
+
+type phraseSearch struct {
+	limit  int
+	result [][]string
+}
+
+func (s *phraseSearch) full() bool {
+	return s.limit > 0 && len(s.result) >= s.limit
+}
+
+// search picks groups in candidate order only, so every set of groups
+// is visited once regardless of the order of its words.
+func (s *phraseSearch) search(rem signature, cands []*tableEntry, path []*tableEntry) {
+	if rem.empty() {
+		s.expand(path, make([]string, 0, len(path)), 0)
+		return
+	}
+
+	var covered signature
+	for _, e := range cands {
+		for i, c := range e.sig {
+			if c > 0 {
+				covered[i] = 1
+			}
+		}
+	}
+	for i, c := range rem {
+		// some letter can't be used by any word left
+		if c > 0 && covered[i] == 0 {
+			return
+		}
+	}
+
+	for i, e := range cands {
+		if s.full() {
+			return
+		}
+
+		next := rem.sub(e.sig)
+		fit := make([]*tableEntry, 0, len(cands)-i)
+		for _, c := range cands[i:] {
+			if next.contains(c.sig) {
+				fit = append(fit, c)
+			}
+		}
+
+		s.search(next, fit, append(path, e))
+	}
+}
+
+// expand turns a set of groups into phrases, a group repeated in a row
+// takes words in non-decreasing order to skip permutations.
+func (s *phraseSearch) expand(path []*tableEntry, phrase []string, from int) {
+	if len(phrase) == len(path) {
+		s.result = append(s.result, append([]string(nil), phrase...))
+		return
+	}
+
+	e := path[len(phrase)]
+	start := 0
+	if len(phrase) > 0 && path[len(phrase)-1] == e {
+		start = from
+	}
+	for i := start; i < len(e.words); i++ {
+		if s.full() {
+			return
+		}
+		s.expand(path, append(phrase, e.words[i]), i)
+	}
+}
+
+// Index returns the anagram sets of the table in first-seen order.
+func (t *SignatureTable) Index() *AnagramIndex {
+	var groups []*tableEntry
+	for i := range t.entries {
+		if len(t.entries[i].words) > 1 {
+			groups = append(groups, &t.entries[i])
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].rank < groups[j].rank
+	})
+
+	idx := &AnagramIndex{
+		keys:  make([]string, 0, len(groups)),
+		sets:  make(map[string][]string, len(groups)),
+		bySig: make(map[signature]string, len(groups)),
+	}
+	for _, e := range groups {
+		idx.keys = append(idx.keys, e.key)
+		idx.sets[e.key] = e.words
+		idx.bySig[e.sig] = e.key
+	}
+
+	return idx
+}
+
+// WriteTo stores the table as:
+// magic, version, uvarint number of entries, then per entry
+// signature bytes, uvarint rank, uvarint key position in words,
+// uvarint number of words and length-prefixed words.
+func (t *SignatureTable) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var written int64
+	var buf [binary.MaxVarintLen64]byte
+
+	write := func(p []byte) error {
+		n, err := bw.Write(p)
+		written += int64(n)
+		return err
+	}
+	writeUvarint := func(v int) error {
+		return write(buf[:binary.PutUvarint(buf[:], uint64(v))])
+	}
+
+	if err := write(append([]byte(tableMagic), tableVersion)); err != nil {
+		return written, err
+	}
+	if err := writeUvarint(len(t.entries)); err != nil {
+		return written, err
+	}
+	for _, e := range t.entries {
+		if err := write(e.sig[:]); err != nil {
+			return written, err
+		}
+		if err := writeUvarint(e.rank); err != nil {
+			return written, err
+		}
+		if err := writeUvarint(sort.SearchStrings(e.words, e.key)); err != nil {
+			return written, err
+		}
+		if err := writeUvarint(len(e.words)); err != nil {
+			return written, err
+		}
+		for _, word := range e.words {
+			if err := writeUvarint(len(word)); err != nil {
+				return written, err
+			}
+			if err := write([]byte(word)); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, bw.Flush()
+}
+
+// ReadTable .
+func ReadTable(r io.Reader) (*SignatureTable, error) {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, len(tableMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("%w: %s", errorBadTable, err.Error())
+	}
+	if string(header[:len(tableMagic)]) != tableMagic || header[len(tableMagic)] != tableVersion {
+		return nil, errorBadTable
+	}
+
+	readUvarint := func() (int, error) {
+		v, err := binary.ReadUvarint(br)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", errorBadTable, err.Error())
+		}
+		if v > math.MaxInt32 {
+			return 0, errorBadTable
+		}
+		return int(v), nil
+	}
+
+	n, err := readUvarint()
+	if err != nil {
+		return nil, err
+	}
+
+	// n comes from the file, don't trust it with the allocation
+	t := &SignatureTable{
+		entries: make([]tableEntry, 0, min(n, 1<<16)),
+	}
+	for i := 0; i < n; i++ {
+		var e tableEntry
+		if _, err := io.ReadFull(br, e.sig[:]); err != nil {
+			return nil, fmt.Errorf("%w: %s", errorBadTable, err.Error())
+		}
+		// lookups are a binary search, one signature per entry in order
+		if i > 0 && bytes.Compare(t.entries[i-1].sig[:], e.sig[:]) >= 0 {
+			return nil, fmt.Errorf("%w: entries out of order", errorBadTable)
+		}
+		if e.rank, err = readUvarint(); err != nil {
+			return nil, err
+		}
+		keyPos, err := readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		count, err := readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		if keyPos >= count {
+			return nil, errorBadTable
+		}
+
+		e.words = make([]string, 0, min(count, 1<<10))
+		for k := 0; k < count; k++ {
+			size, err := readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			if size > maxTableWord {
+				return nil, fmt.Errorf("%w: word of %d bytes", errorBadTable, size)
+			}
+			word := make([]byte, size)
+			if _, err := io.ReadFull(br, word); err != nil {
+				return nil, fmt.Errorf("%w: %s", errorBadTable, err.Error())
+			}
+			e.words = append(e.words, string(word))
+		}
+		e.key = e.words[keyPos]
+
+		t.entries = append(t.entries, e)
+	}
+
+	return t, nil
+}
+
+type indexBuilder struct {
+	order []signature
+	keys  map[signature]string
+	sets  map[signature][]string
+	seen  map[string]struct{}
+}
+
+func newIndexBuilder() *indexBuilder {
+	return &indexBuilder{
+		keys: make(map[signature]string),
+		sets: make(map[signature][]string),
+		seen: make(map[string]struct{}),
+	}
+}
+
+// add expects a lowercased word and its signature, a zero signature marks
+// a word that is not valid or overflows one, it's skipped.
+func (b *indexBuilder) add(word string, sig signature) {
+	if sig.empty() {
+		return
+	}
+	if _, ok := b.seen[word]; ok {
+		return
+	}
+	b.seen[word] = struct{}{}
+
+	if _, ok := b.keys[sig]; !ok {
+		b.keys[sig] = word
+		b.order = append(b.order, sig)
+	}
+	b.sets[sig] = append(b.sets[sig], word)
+}
+
+func (b *indexBuilder) table() *SignatureTable {
+	t := &SignatureTable{
+		entries: make([]tableEntry, 0, len(b.order)),
+	}
+	for rank, sig := range b.order {
+		set := b.sets[sig]
+
+		words := make([]string, len(set))
+		copy(words, set)
+		sort.Strings(words)
+
+		t.entries = append(t.entries, tableEntry{
+			sig:   sig,
+			rank:  rank,
+			key:   b.keys[sig],
+			words: words,
+		})
+	}
+	t.sort()
+
+	return t
+}
+
+func (b *indexBuilder) build() *AnagramIndex {
+	return b.table().Index()
+}
+
+// AnagramSet .
+func AnagramSet(arr []string) *AnagramIndex {
+	b := newIndexBuilder()
 	for _, word := range arr {
 		word = strings.ToLower(word)
-		h := hash(word)
-		mapSet[h] = append(mapSet[h], word)
+		if sig, ok := newSignature(word); ok {
+			b.add(word, sig)
+		}
+	}
+
+	return b.build()
+}
+
+type batch struct {
+	seq   int
+	words []string
+	sigs  []signature
+}
+
+// BuildIndex .
+func BuildIndex(r io.Reader, n int) (*AnagramIndex, error) {
+	t, err := BuildTable(r, n)
+	if err != nil {
+		return nil, err
 	}
+	return t.Index(), nil
+}
+
+// BuildTable reads a dictionary with one word per line. Hashing is spread
+// over n goroutines, batches are merged in input order so keys stay first-seen.
+func BuildTable(r io.Reader, n int) (*SignatureTable, error) {
+	if n < 1 {
+		n = 1
+	}
+
+	jobs := make(chan *batch, n)
+	results := make(chan *batch, n)
 
-	result := make(map[string][]string, len(mapSet))
-	for _, set := range mapSet {
-		if len(set) > 1 {
-			key := set[0]
-			sort.Strings(set)
-			result[key] = set
+	var readErr error
+	go func() {
+		defer close(jobs)
+
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+		seq := 0
+		words := make([]string, 0, batchSize)
+		for scanner.Scan() {
+			word := strings.TrimSpace(scanner.Text())
+			if word == "" {
+				continue
+			}
+			words = append(words, word)
+			if len(words) == batchSize {
+				jobs <- &batch{seq: seq, words: words}
+				seq++
+				words = make([]string, 0, batchSize)
+			}
 		}
+		if len(words) > 0 {
+			jobs <- &batch{seq: seq, words: words}
+		}
+		readErr = scanner.Err()
+	}()
+
+	done := make(chan struct{})
+	for i := 0; i < n; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for b := range jobs {
+				b.sigs = make([]signature, len(b.words))
+				for i, word := range b.words {
+					word = strings.ToLower(word)
+					b.words[i] = word
+					if sig, ok := newSignature(word); ok {
+						b.sigs[i] = sig
+					}
+				}
+				results <- b
+			}
+		}()
 	}
+	go func() {
+		for i := 0; i < n; i++ {
+			<-done
+		}
+		close(results)
+	}()
+
+	builder := newIndexBuilder()
+	pending := make(map[int]*batch)
+	next := 0
+	for b := range results {
+		pending[b.seq] = b
+		for {
+			b, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
 
-	return &result
+			for i, word := range b.words {
+				builder.add(word, b.sigs[i])
+			}
+		}
+	}
+
+	if readErr != nil {
+		return nil, fmt.Errorf("Error in BuildTable - scanner.Scan(): %w", readErr)
+	}
+
+	return builder.table(), nil
+}
+
+func printText(w io.Writer, idx *AnagramIndex, minSize int) error {
+	var err error
+	idx.Range(func(key string, set []string) bool {
+		if len(set) < minSize {
+			return true
+		}
+		_, err = fmt.Fprintf(w, "%s: %s\n", key, strings.Join(set, " "))
+		return err == nil
+	})
+	return err
+}
+
+func printJSON(w io.Writer, idx *AnagramIndex, minSize int) error {
+	type set struct {
+		Key   string   `json:"key"`
+		Words []string `json:"words"`
+	}
+
+	sets := make([]set, 0, idx.Len())
+	idx.Range(func(key string, words []string) bool {
+		if len(words) >= minSize {
+			sets = append(sets, set{Key: key, Words: words})
+		}
+		return true
+	})
+
+	return json.NewEncoder(w).Encode(sets)
+}
+
+func printWords(w io.Writer, words []string, sep string) error {
+	if jsonOutput {
+		if words == nil {
+			words = []string{}
+		}
+		return json.NewEncoder(w).Encode(words)
+	}
+	if len(words) > 0 {
+		_, err := fmt.Fprintln(w, strings.Join(words, sep))
+		return err
+	}
+	return nil
+}
+
+func lookup(w io.Writer, t *SignatureTable, word string) error {
+	word = strings.ToLower(word)
+	if !validWord(word) {
+		return fmt.Errorf("%w: %s", errorInvalidWord, word)
+	}
+
+	set := t.Anagrams(word)
+	anagrams := make([]string, 0, len(set))
+	for _, w := range set {
+		if w != word {
+			anagrams = append(anagrams, w)
+		}
+	}
+
+	return printWords(w, anagrams, " ")
+}
+
+func subAnagrams(w io.Writer, t *SignatureTable, letters string) error {
+	letters = strings.ToLower(letters)
+	if !validWord(letters) {
+		return fmt.Errorf("%w: %s", errorInvalidWord, letters)
+	}
+
+	if !phrases {
+		return printWords(w, t.SubAnagrams(letters, limit), "\n")
+	}
+
+	result := t.Phrases(letters, limit)
+	if jsonOutput {
+		if result == nil {
+			result = [][]string{}
+		}
+		return json.NewEncoder(w).Encode(result)
+	}
+	for _, phrase := range result {
+		if _, err := fmt.Fprintln(w, strings.Join(phrase, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readDictionaries(fileNames []string) (*SignatureTable, error) {
+	if len(fileNames) == 0 {
+		return BuildTable(os.Stdin, workers)
+	}
+
+	readers := make([]io.Reader, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		input, err := os.Open(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errorFileNotFound, fileName)
+		}
+		defer input.Close()
+		readers = append(readers, input)
+	}
+
+	return BuildTable(io.MultiReader(readers...), workers)
+}
+
+func loadTable(fileName string) (*SignatureTable, error) {
+	input, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errorFileNotFound, fileName)
+	}
+	defer input.Close()
+
+	return ReadTable(input)
+}
+
+func saveTable(fileName string, t *SignatureTable) error {
+	output, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	if _, err = t.WriteTo(output); err != nil {
+		output.Close()
+		return err
+	}
+	return output.Close()
 }
 
 func main() {
-	dict := []string{"тяпка", "пятка"}
-	fmt.Println(AnagramSet(dict))
+	flag.Parse()
+	fileNames = flag.Args()
+
+	if minSize < 2 {
+		fmt.Fprintln(os.Stderr, errorMinSize)
+		os.Exit(2)
+	}
+
+	var t *SignatureTable
+	var err error
+	if indexFile != "" {
+		t, err = loadTable(indexFile)
+	} else {
+		t, err = readDictionaries(fileNames)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if saveFile != "" {
+		if err = saveTable(saveFile, t); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+
+	switch {
+	case lookupWord != "":
+		err = lookup(out, t, lookupWord)
+	case letters != "":
+		err = subAnagrams(out, t, letters)
+	case saveFile != "":
+		// only building the index was requested
+	case jsonOutput:
+		err = printJSON(out, t.Index(), minSize)
+	default:
+		err = printText(out, t.Index(), minSize)
+	}
+
+	// what was found before an error is still printed
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
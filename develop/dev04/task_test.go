@@ -1,18 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
 )
 
 func TestAnagramSet(t *testing.T) {
 	testTable := []struct {
 		input  []string
-		result *map[string][]string
+		result map[string][]string
 	}{
 		{
 			input: []string{"пятак", "пятка", "тяпка", "листок", "слиток", "столик"},
-			result: &map[string][]string{
+			result: map[string][]string{
 				"пятак": []string{
 					"пятак",
 					"пятка",
@@ -27,7 +30,7 @@ func TestAnagramSet(t *testing.T) {
 		},
 		{
 			input: []string{"тяпка", "листок", "слиток", "столик"},
-			result: &map[string][]string{
+			result: map[string][]string{
 				"листок": []string{
 					"листок",
 					"слиток",
@@ -37,7 +40,7 @@ func TestAnagramSet(t *testing.T) {
 		},
 		{
 			input: []string{"тяпка", "слиток", "листок", "столик"},
-			result: &map[string][]string{
+			result: map[string][]string{
 				"слиток": []string{
 					"листок",
 					"слиток",
@@ -47,15 +50,15 @@ func TestAnagramSet(t *testing.T) {
 		},
 		{
 			input:  []string{"тяпка", "столик"},
-			result: &map[string][]string{},
+			result: map[string][]string{},
 		},
 		{
 			input:  []string{},
-			result: &map[string][]string{},
+			result: map[string][]string{},
 		},
 		{
 			input:  []string{"б"},
-			result: &map[string][]string{},
+			result: map[string][]string{},
 		},
 	}
 
@@ -64,43 +67,257 @@ func TestAnagramSet(t *testing.T) {
 
 		t.Logf("Calling anagramSet(%v), result %v", testCase.input, result)
 
-		if !reflect.DeepEqual(*result, *testCase.result) {
+		if !reflect.DeepEqual(result.Map(), testCase.result) {
 			t.Errorf("Incorrect result: expect %v, got %v",
 				testCase.result, result)
 		}
 	}
 }
 
-func TestHash(t *testing.T) {
+func TestAnagramSetDuplicates(t *testing.T) {
+	input := []string{"Пятак", "пятка", "пятак", "ПЯТКА", "тяпка"}
+	expect := map[string][]string{
+		"пятак": {"пятак", "пятка", "тяпка"},
+	}
+
+	result := AnagramSet(input)
+	if !reflect.DeepEqual(result.Map(), expect) {
+		t.Errorf("Incorrect result: expect %v, got %v", expect, result)
+	}
+}
+
+func TestAnagramSetKeysOrder(t *testing.T) {
+	input := []string{"слиток", "тяпка", "листок", "пятак", "столик"}
+	expect := []string{"слиток", "тяпка"}
+
+	result := AnagramSet(input)
+	if keys := result.Keys(); !reflect.DeepEqual(keys, expect) {
+		t.Errorf("Incorrect keys: expect %v, got %v", expect, keys)
+	}
+
+	var ranged []string
+	result.Range(func(key string, set []string) bool {
+		ranged = append(ranged, key)
+		return true
+	})
+	if !reflect.DeepEqual(ranged, expect) {
+		t.Errorf("Incorrect range order: expect %v, got %v", expect, ranged)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	testTable := []struct {
+		input  string
+		result signature
+		ok     bool
+	}{
+		{input: "абвгд", result: signature{1, 1, 1, 1, 1}, ok: true},
+		{input: "е", result: signature{5: 1}, ok: true},
+		{input: "ё", result: signature{32: 1}, ok: true},
+		{input: "", result: signature{}, ok: true},
+		{input: strings.Repeat("я", 255), result: signature{31: 255}, ok: true},
+		{input: strings.Repeat("я", 256), result: signature{}, ok: false},
+		{input: "кот1", result: signature{}, ok: false},
+		{input: "ѐ", result: signature{}, ok: false},
+	}
+
+	for _, testCase := range testTable {
+		result, ok := newSignature(testCase.input)
+		if result != testCase.result || ok != testCase.ok {
+			t.Errorf("Incorrect result for %.10q: expect %v %v, got %v %v", testCase.input, testCase.result, testCase.ok, result, ok)
+		}
+	}
+}
+
+func TestBuildTableSkipsOverflow(t *testing.T) {
+	dict := strings.Repeat("а", 255) + "\n" + strings.Repeat("а", 256) + "\nкот\nток\n"
+	table, err := BuildTable(strings.NewReader(dict), 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if table.Len() != 2 {
+		t.Errorf("Incorrect result: expect %d entries, got %d", 2, table.Len())
+	}
+	if _, ok := table.Index().Lookup(strings.Repeat("а", 256)); ok {
+		t.Error("Expected no set for a word of 256 equal letters")
+	}
+}
+
+func TestBuildIndex(t *testing.T) {
+	dict := []string{"пятак", "Листок", "", "пятка", "word", "слиток", "пятак", "тяпка", "столик", "кот"}
+	expect := AnagramSet(dict)
+
+	for _, workers := range []int{1, 4} {
+		result, err := BuildIndex(strings.NewReader(strings.Join(dict, "\n")), workers)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if !reflect.DeepEqual(result.Keys(), expect.Keys()) || !reflect.DeepEqual(result.Map(), expect.Map()) {
+			t.Errorf("Incorrect result with %d workers: expect %v, got %v", workers, expect, result)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	idx := AnagramSet([]string{"пятак", "пятка", "тяпка", "кот"})
+
 	testTable := []struct {
 		input  string
-		result string
+		result []string
+		ok     bool
 	}{
+		{input: "Тяпка", result: []string{"пятак", "пятка", "тяпка"}, ok: true},
+		{input: "капят", result: []string{"пятак", "пятка", "тяпка"}, ok: true},
+		{input: "кот", ok: false},
+		{input: "word", ok: false},
+	}
+
+	for _, testCase := range testTable {
+		result, ok := idx.Lookup(testCase.input)
+		if ok != testCase.ok || !reflect.DeepEqual(result, testCase.result) {
+			t.Errorf("Incorrect result for %s: expect %v %v, got %v %v",
+				testCase.input, testCase.result, testCase.ok, result, ok)
+		}
+	}
+}
+
+func TestSignatureTable(t *testing.T) {
+	dict := "пятак\nпятка\nтяпка\nкот\nток\nтак\nкат\nпят\nа\n"
+	table, err := BuildTable(strings.NewReader(dict), 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	buf := bytes.Buffer{}
+	if _, err = table.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	loaded, err := ReadTable(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(loaded, table) {
+		t.Errorf("Incorrect table after reading: expect %v, got %v", table, loaded)
+	}
+
+	expect := AnagramSet(strings.Fields(dict))
+	if !reflect.DeepEqual(loaded.Index(), expect) {
+		t.Errorf("Incorrect index: expect %v, got %v", expect, loaded.Index())
+	}
+
+	if result := loaded.Anagrams("Катяп"); !reflect.DeepEqual(result, []string{"пятак", "пятка", "тяпка"}) {
+		t.Errorf("Incorrect anagrams: got %v", result)
+	}
+	if result := loaded.Anagrams("кто-то"); result != nil {
+		t.Errorf("Incorrect anagrams: expect nil, got %v", result)
+	}
+}
+
+func TestSubAnagrams(t *testing.T) {
+	table, err := BuildTable(strings.NewReader("пятак\nпятка\nкот\nток\nтак\nкат\nпят\nа\nяяя\n"), 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testTable := []struct {
+		input  string
+		result []string
+	}{
+		{input: "пятак", result: []string{"пятак", "пятка", "кат", "пят", "так", "а"}},
+		{input: "кота", result: []string{"кат", "кот", "так", "ток", "а"}},
+		{input: "б", result: nil},
+	}
+
+	for _, testCase := range testTable {
+		result := table.SubAnagrams(testCase.input, 0)
+		if !reflect.DeepEqual(result, testCase.result) {
+			t.Errorf("Incorrect result for %s: expect %v, got %v", testCase.input, testCase.result, result)
+		}
+	}
+}
+
+func TestReadTableInvalid(t *testing.T) {
+	entry := "ANAG\x01\x01" + strings.Repeat("\x00", len(signature{}))
+	inputs := []string{
+		"", "ANAG", "XXXX\x01", "ANAG\x01\x05",
+		// word size and word count near MaxInt32 must not be allocated
+		entry + "\x00\x00\x01\xff\xff\xff\xff\x07",
+		entry + "\x00\x00\xff\xff\xff\xff\x07\x01a",
+	}
+	for _, input := range inputs {
+		if _, err := ReadTable(strings.NewReader(input)); !errors.Is(err, errorBadTable) {
+			t.Errorf("Expected %v for %q, got %v", errorBadTable, input, err)
+		}
+	}
+	// entries out of order break the binary search of lookups
+	table, err := BuildTable(strings.NewReader("кот\nток\nмир\n"), 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, entries := range [][]tableEntry{
+		{table.entries[1], table.entries[0]},
+		{table.entries[0], table.entries[0]},
+	} {
+		var buf bytes.Buffer
+		if _, err := (&SignatureTable{entries: entries}).WriteTo(&buf); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := ReadTable(&buf); !errors.Is(err, errorBadTable) {
+			t.Errorf("Expected %v for entries out of order, got %v", errorBadTable, err)
+		}
+	}
+}
+
+func TestPhrases(t *testing.T) {
+	table, err := BuildTable(strings.NewReader("кот\nток\nкит\nмир\nрим\nмиркот\nа\nя\n"), 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testTable := []struct {
+		input  string
+		limit  int
+		result [][]string
+	}{
+		{
+			input: "котмир",
+			result: [][]string{
+				{"миркот"},
+				{"кот", "мир"},
+				{"кот", "рим"},
+				{"ток", "мир"},
+				{"ток", "рим"},
+			},
+		},
+		{
+			input:  "котмир",
+			limit:  2,
+			result: [][]string{{"миркот"}, {"кот", "мир"}},
+		},
 		{
-			input:  "абвгд",
-			result: "[1 1 1 1 1 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0]",
+			input:  "коткот",
+			result: [][]string{{"кот", "кот"}, {"кот", "ток"}, {"ток", "ток"}},
 		},
 		{
-			input:  "е",
-			result: "[0 0 0 0 0 1 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0]",
+			input:  "котаа",
+			result: [][]string{{"кот", "а", "а"}, {"ток", "а", "а"}},
 		},
 		{
-			input:  "ё",
-			result: "[0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 1]",
+			input:  "котб",
+			result: nil,
 		},
 		{
 			input:  "",
-			result: "[0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0]",
+			result: nil,
 		},
 	}
 
 	for _, testCase := range testTable {
-		result := hash(testCase.input)
-
-		t.Logf("Calling hash(%s), result %s", testCase.input, result)
-
-		if result != testCase.result {
-			t.Errorf("Incorrect result: expect %s, got %s", testCase.result, result)
+		result := table.Phrases(testCase.input, testCase.limit)
+		if !reflect.DeepEqual(result, testCase.result) {
+			t.Errorf("Incorrect result for %s: expect %v, got %v", testCase.input, testCase.result, result)
 		}
 	}
 }
@@ -0,0 +1,1197 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The -P engine is a backtracking matcher for the PCRE features RE2 lacks:
+// backreferences, lookahead, lookbehind, atomic groups and possessive
+// quantifiers. Every call of the matcher costs a step, a search over a line
+// that takes more than --step-limit steps fails with errorStepLimit instead
+// of hanging on a catastrophic pattern.
+//
+// The matcher recurses through continuations, so a repetition that can't be
+// run as a loop nests a call per iteration. Nesting deeper than maxDepth
+// fails with errorDepthLimit whatever the step limit is, a stack overflow
+// can't be recovered from.
+
+// maxDepth bounds nested matcher calls, with the closures of continuations
+// a call takes up to a few hundred bytes of stack, this keeps it far from
+// the 1GB goroutine stack limit.
+const maxDepth = 1 << 18
+
+type reOp uint8
+
+const (
+	opEmpty reOp = iota
+	opLiteral
+	opClass
+	opAny
+	opBegin
+	opEnd
+	opWordBoundary
+	opNonWordBoundary
+	opConcat
+	opAlternate
+	opRepeat
+	opCapture
+	opBackref
+	opLookahead
+	opLookbehind
+	opAtomic
+)
+
+type reNode struct {
+	op   reOp
+	subs []*reNode
+
+	r     rune
+	class *reClass
+	fold  bool
+
+	// opRepeat, max is -1 for no limit
+	min, max int
+	greedy   bool
+
+	// opCapture and opBackref
+	group int
+	name  string
+
+	// opLookahead and opLookbehind
+	negate bool
+}
+
+type reRange struct {
+	lo, hi rune
+}
+
+// reClass matches runes in ranges or tables or out of any of not.
+type reClass struct {
+	ranges []reRange
+	tables []*unicode.RangeTable
+	not    []*reClass
+	negate bool
+}
+
+func (c *reClass) contains(r rune) bool {
+	for _, rg := range c.ranges {
+		if rg.lo <= r && r <= rg.hi {
+			return true
+		}
+	}
+	for _, table := range c.tables {
+		if unicode.Is(table, r) {
+			return true
+		}
+	}
+	for _, sub := range c.not {
+		if !sub.contains(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *reClass) match(r rune, fold bool) bool {
+	found := c.contains(r)
+	if !found && fold {
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if c.contains(f) {
+				found = true
+				break
+			}
+		}
+	}
+	return found != c.negate
+}
+
+var (
+	digitRanges = []reRange{{'0', '9'}}
+	spaceRanges = []reRange{{'\t', '\r'}, {' ', ' '}}
+	wordTables  = []*unicode.RangeTable{unicode.Letter, unicode.Number}
+)
+
+// perlClass builds \d, \s, \w and their negations, \w is Unicode like -w.
+func perlClass(c rune) *reClass {
+	class := &reClass{}
+	switch unicode.ToLower(c) {
+	case 'd':
+		class.ranges = digitRanges
+	case 's':
+		class.ranges = spaceRanges
+	case 'w':
+		class.ranges = []reRange{{'_', '_'}}
+		class.tables = wordTables
+	}
+	class.negate = unicode.IsUpper(c)
+	return class
+}
+
+var posixClasses = map[string][]reRange{
+	"alnum":  {{'0', '9'}, {'A', 'Z'}, {'a', 'z'}},
+	"alpha":  {{'A', 'Z'}, {'a', 'z'}},
+	"blank":  {{'\t', '\t'}, {' ', ' '}},
+	"cntrl":  {{0, 31}, {127, 127}},
+	"digit":  {{'0', '9'}},
+	"graph":  {{'!', '~'}},
+	"lower":  {{'a', 'z'}},
+	"print":  {{' ', '~'}},
+	"punct":  {{'!', '/'}, {':', '@'}, {'[', '`'}, {'{', '~'}},
+	"space":  {{'\t', '\r'}, {' ', ' '}},
+	"upper":  {{'A', 'Z'}},
+	"word":   {{'0', '9'}, {'A', 'Z'}, {'a', 'z'}, {'_', '_'}},
+	"xdigit": {{'0', '9'}, {'A', 'F'}, {'a', 'f'}},
+}
+
+// reParser is a recursive descent parser of PCRE syntax.
+type reParser struct {
+	src    string
+	pos    int
+	fold   bool
+	groups int
+	names  map[string]int
+	refs   []*reNode
+}
+
+func (p *reParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *reParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *reParser) peek() rune {
+	r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+	return r
+}
+
+func (p *reParser) next() rune {
+	r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+	p.pos += size
+	return r
+}
+
+func (p *reParser) consume(prefix string) bool {
+	if strings.HasPrefix(p.src[p.pos:], prefix) {
+		p.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+func parsePerl(pattern string) (*reNode, *reParser, error) {
+	p := &reParser{src: pattern, names: make(map[string]int)}
+
+	node, err := p.parseAlternate()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !p.eof() {
+		return nil, nil, p.errorf("unmatched )")
+	}
+
+	for _, ref := range p.refs {
+		if ref.name != "" {
+			group, ok := p.names[ref.name]
+			if !ok {
+				return nil, nil, fmt.Errorf("reference to non-existent group %s", ref.name)
+			}
+			ref.group = group
+		}
+		if ref.group > p.groups {
+			return nil, nil, fmt.Errorf("reference to non-existent group %d", ref.group)
+		}
+	}
+
+	return node, p, nil
+}
+
+func (p *reParser) parseAlternate() (*reNode, error) {
+	// inline flags last until the end of the group
+	fold := p.fold
+	defer func() { p.fold = fold }()
+
+	var subs []*reNode
+	for {
+		node, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, node)
+		if !p.consume("|") {
+			break
+		}
+	}
+
+	if len(subs) == 1 {
+		return subs[0], nil
+	}
+	return &reNode{op: opAlternate, subs: subs}, nil
+}
+
+func (p *reParser) parseConcat() (*reNode, error) {
+	var subs []*reNode
+	for !p.eof() && p.peek() != '|' && p.peek() != ')' {
+		node, err := p.parseRepeat()
+		if err != nil {
+			return nil, err
+		}
+		if node != nil {
+			subs = append(subs, node)
+		}
+	}
+
+	switch len(subs) {
+	case 0:
+		return &reNode{op: opEmpty}, nil
+	case 1:
+		return subs[0], nil
+	}
+	return &reNode{op: opConcat, subs: subs}, nil
+}
+
+func (p *reParser) parseRepeat() (*reNode, error) {
+	start := p.pos
+	atom, err := p.parseAtom()
+	if err != nil || atom == nil {
+		return atom, err
+	}
+
+	for !p.eof() {
+		min, max := -1, -1
+		switch p.peek() {
+		case '*':
+			p.next()
+			min, max = 0, -1
+		case '+':
+			p.next()
+			min, max = 1, -1
+		case '?':
+			p.next()
+			min, max = 0, 1
+		case '{':
+			var ok bool
+			if min, max, ok = p.parseBraces(); !ok {
+				return atom, nil
+			}
+		default:
+			return atom, nil
+		}
+
+		switch atom.op {
+		case opBegin, opEnd, opWordBoundary, opNonWordBoundary, opLookahead, opLookbehind:
+			return nil, fmt.Errorf("at offset %d: quantifier does not follow a repeatable item", start)
+		}
+
+		node := &reNode{op: opRepeat, subs: []*reNode{atom}, min: min, max: max, greedy: true}
+		switch {
+		case p.consume("?"):
+			node.greedy = false
+		case p.consume("+"):
+			node = &reNode{op: opAtomic, subs: []*reNode{node}}
+		}
+		atom = node
+	}
+	return atom, nil
+}
+
+// parseBraces reads {n}, {n,} or {n,m}, anything else is a literal '{'.
+func (p *reParser) parseBraces() (int, int, bool) {
+	end := strings.IndexByte(p.src[p.pos:], '}')
+	if end < 0 {
+		return 0, 0, false
+	}
+	body := p.src[p.pos+1 : p.pos+end]
+
+	lo, hi, hasComma := body, body, false
+	if i := strings.IndexByte(body, ','); i >= 0 {
+		lo, hi, hasComma = body[:i], body[i+1:], true
+	}
+	min, err := strconv.Atoi(lo)
+	if err != nil || min < 0 {
+		return 0, 0, false
+	}
+	max := min
+	if hasComma {
+		max = -1
+		if hi != "" {
+			if max, err = strconv.Atoi(hi); err != nil || max < min {
+				return 0, 0, false
+			}
+		}
+	}
+
+	p.pos += end + 1
+	return min, max, true
+}
+
+func (p *reParser) parseAtom() (*reNode, error) {
+	switch r := p.next(); r {
+	case '(':
+		return p.parseGroup()
+	case '[':
+		class, err := p.parseClass()
+		if err != nil {
+			return nil, err
+		}
+		return &reNode{op: opClass, class: class, fold: p.fold}, nil
+	case '.':
+		return &reNode{op: opAny}, nil
+	case '^':
+		return &reNode{op: opBegin}, nil
+	case '$':
+		return &reNode{op: opEnd}, nil
+	case '\\':
+		return p.parseEscape()
+	case '*', '+', '?':
+		return nil, p.errorf("quantifier does not follow a repeatable item")
+	default:
+		return &reNode{op: opLiteral, r: r, fold: p.fold}, nil
+	}
+}
+
+func (p *reParser) parseGroup() (*reNode, error) {
+	var node *reNode
+	switch {
+	case p.consume("?#"):
+		end := strings.IndexByte(p.src[p.pos:], ')')
+		if end < 0 {
+			return nil, p.errorf("missing ) after comment")
+		}
+		p.pos += end + 1
+		return nil, nil
+	case p.consume("?:"):
+		node = &reNode{op: opConcat}
+	case p.consume("?="):
+		node = &reNode{op: opLookahead}
+	case p.consume("?!"):
+		node = &reNode{op: opLookahead, negate: true}
+	case p.consume("?<="):
+		node = &reNode{op: opLookbehind}
+	case p.consume("?<!"):
+		node = &reNode{op: opLookbehind, negate: true}
+	case p.consume("?>"):
+		node = &reNode{op: opAtomic}
+	case p.consume("?P<"), p.consume("?<"), p.consume("?'"):
+		closing := ">"
+		if p.src[p.pos-1] == '\'' {
+			closing = "'"
+		}
+		end := strings.Index(p.src[p.pos:], closing)
+		if end <= 0 {
+			return nil, p.errorf("invalid group name")
+		}
+		name := p.src[p.pos : p.pos+end]
+		if _, ok := p.names[name]; ok {
+			return nil, p.errorf("two named groups have the same name %s", name)
+		}
+		p.pos += end + 1
+		p.groups++
+		p.names[name] = p.groups
+		node = &reNode{op: opCapture, group: p.groups, name: name}
+	case p.consume("?"):
+		return p.parseFlags()
+	default:
+		p.groups++
+		node = &reNode{op: opCapture, group: p.groups}
+	}
+
+	sub, err := p.parseAlternate()
+	if err != nil {
+		return nil, err
+	}
+	if !p.consume(")") {
+		return nil, p.errorf("missing )")
+	}
+
+	if node.op == opConcat {
+		return sub, nil
+	}
+	node.subs = []*reNode{sub}
+	return node, nil
+}
+
+// parseFlags handles (?i), (?-i) and (?i:...), s, m and x are not supported.
+func (p *reParser) parseFlags() (*reNode, error) {
+	fold := p.fold
+	on := true
+	for !p.eof() {
+		switch r := p.next(); r {
+		case 'i':
+			fold = on
+		case '-':
+			on = false
+		case ')':
+			p.fold = fold
+			return nil, nil
+		case ':':
+			saved := p.fold
+			p.fold = fold
+			sub, err := p.parseAlternate()
+			p.fold = saved
+			if err != nil {
+				return nil, err
+			}
+			if !p.consume(")") {
+				return nil, p.errorf("missing )")
+			}
+			return sub, nil
+		default:
+			return nil, p.errorf("unsupported group flag %q", r)
+		}
+	}
+	return nil, p.errorf("missing )")
+}
+
+func (p *reParser) parseEscape() (*reNode, error) {
+	if p.eof() {
+		return nil, p.errorf("\\ at end of pattern")
+	}
+
+	switch r := p.peek(); {
+	case r >= '1' && r <= '9':
+		return p.backref(p.parseNumber()), nil
+	case r == 'k':
+		p.next()
+		for _, quotes := range []string{"<>", "{}", "''"} {
+			if p.consume(quotes[:1]) {
+				end := strings.IndexByte(p.src[p.pos:], quotes[1])
+				if end <= 0 {
+					return nil, p.errorf("invalid group name")
+				}
+				name := p.src[p.pos : p.pos+end]
+				p.pos += end + 1
+				node := p.backref(0)
+				node.name = name
+				return node, nil
+			}
+		}
+		return nil, p.errorf("\\k is not followed by a group name")
+	case r == 'g':
+		p.next()
+		braced := p.consume("{")
+		if p.eof() || p.peek() < '0' || p.peek() > '9' {
+			return nil, p.errorf("\\g is not followed by a group number")
+		}
+		group := p.parseNumber()
+		if braced && !p.consume("}") {
+			return nil, p.errorf("missing } after \\g")
+		}
+		return p.backref(group), nil
+	case r == 'Q':
+		p.next()
+		end := strings.Index(p.src[p.pos:], `\E`)
+		quoted := p.src[p.pos:]
+		if end >= 0 {
+			quoted = p.src[p.pos : p.pos+end]
+			p.pos += end + 2
+		} else {
+			p.pos = len(p.src)
+		}
+		node := &reNode{op: opConcat}
+		for _, q := range quoted {
+			node.subs = append(node.subs, &reNode{op: opLiteral, r: q, fold: p.fold})
+		}
+		return node, nil
+	case r == 'E':
+		p.next()
+		return nil, nil
+	case r == 'b':
+		p.next()
+		return &reNode{op: opWordBoundary}, nil
+	case r == 'B':
+		p.next()
+		return &reNode{op: opNonWordBoundary}, nil
+	case r == 'A':
+		p.next()
+		return &reNode{op: opBegin}, nil
+	case r == 'z' || r == 'Z':
+		p.next()
+		return &reNode{op: opEnd}, nil
+	}
+
+	class, r, err := p.parseClassEscape()
+	if err != nil {
+		return nil, err
+	}
+	if class != nil {
+		return &reNode{op: opClass, class: class, fold: p.fold}, nil
+	}
+	return &reNode{op: opLiteral, r: r, fold: p.fold}, nil
+}
+
+func (p *reParser) parseNumber() int {
+	n := 0
+	for !p.eof() && p.peek() >= '0' && p.peek() <= '9' && n < 1000 {
+		n = n*10 + int(p.next()-'0')
+	}
+	return n
+}
+
+func (p *reParser) backref(group int) *reNode {
+	node := &reNode{op: opBackref, group: group, fold: p.fold}
+	p.refs = append(p.refs, node)
+	return node
+}
+
+// parseClassEscape reads an escape allowed both in and out of classes,
+// it returns a class for \d, \p{..} and the like or a single rune.
+func (p *reParser) parseClassEscape() (*reClass, rune, error) {
+	switch r := p.next(); r {
+	case 'd', 'D', 's', 'S', 'w', 'W':
+		return perlClass(r), 0, nil
+	case 'p', 'P':
+		name := ""
+		if p.consume("{") {
+			end := strings.IndexByte(p.src[p.pos:], '}')
+			if end < 0 {
+				return nil, 0, p.errorf("missing } after \\%c", r)
+			}
+			name = p.src[p.pos : p.pos+end]
+			p.pos += end + 1
+		} else if !p.eof() {
+			name = string(p.next())
+		}
+		negate := r == 'P'
+		if strings.HasPrefix(name, "^") {
+			negate, name = !negate, name[1:]
+		}
+
+		table := unicode.Categories[name]
+		if table == nil {
+			table = unicode.Scripts[name]
+		}
+		if name == "Any" {
+			return &reClass{ranges: []reRange{{0, unicode.MaxRune}}, negate: negate}, 0, nil
+		}
+		if table == nil {
+			return nil, 0, p.errorf("unknown property name \\p{%s}", name)
+		}
+		return &reClass{tables: []*unicode.RangeTable{table}, negate: negate}, 0, nil
+	case 'n':
+		return nil, '\n', nil
+	case 't':
+		return nil, '\t', nil
+	case 'r':
+		return nil, '\r', nil
+	case 'f':
+		return nil, '\f', nil
+	case 'v':
+		return nil, '\v', nil
+	case 'a':
+		return nil, '\a', nil
+	case 'e':
+		return nil, 0x1b, nil
+	case '0':
+		return nil, 0, nil
+	case 'x':
+		var digits string
+		if p.consume("{") {
+			end := strings.IndexByte(p.src[p.pos:], '}')
+			if end < 0 {
+				return nil, 0, p.errorf("missing } after \\x")
+			}
+			digits = p.src[p.pos : p.pos+end]
+			p.pos += end + 1
+		} else {
+			for len(digits) < 2 && !p.eof() && strings.ContainsRune("0123456789abcdefABCDEF", p.peek()) {
+				digits += string(p.next())
+			}
+		}
+		v, err := strconv.ParseUint(digits, 16, 32)
+		if err != nil || v > unicode.MaxRune {
+			return nil, 0, p.errorf("invalid \\x escape")
+		}
+		return nil, rune(v), nil
+	default:
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return nil, 0, p.errorf("unrecognized escape \\%c", r)
+		}
+		return nil, r, nil
+	}
+}
+
+func (p *reParser) parseClass() (*reClass, error) {
+	class := &reClass{}
+	if p.consume("^") {
+		class.negate = true
+	}
+
+	first := true
+	for {
+		if p.eof() {
+			return nil, p.errorf("missing terminating ] for character class")
+		}
+		if p.peek() == ']' && !first {
+			p.next()
+			return class, nil
+		}
+		first = false
+
+		if p.consume("[:") {
+			end := strings.Index(p.src[p.pos:], ":]")
+			if end < 0 {
+				return nil, p.errorf("invalid POSIX class")
+			}
+			name := p.src[p.pos : p.pos+end]
+			p.pos += end + 2
+			ranges, ok := posixClasses[strings.TrimPrefix(name, "^")]
+			if !ok {
+				return nil, p.errorf("unknown POSIX class name %s", name)
+			}
+			if strings.HasPrefix(name, "^") {
+				class.not = append(class.not, &reClass{ranges: ranges})
+			} else {
+				class.ranges = append(class.ranges, ranges...)
+			}
+			continue
+		}
+
+		lo, isClass, err := p.parseClassRune(class)
+		if err != nil {
+			return nil, err
+		}
+		if isClass {
+			continue
+		}
+
+		if strings.HasPrefix(p.src[p.pos:], "-") && !strings.HasPrefix(p.src[p.pos:], "-]") {
+			p.next()
+			hi, isClass, err := p.parseClassRune(class)
+			if err != nil {
+				return nil, err
+			}
+			if isClass {
+				return nil, p.errorf("invalid range in character class")
+			}
+			if hi < lo {
+				return nil, p.errorf("range out of order in character class")
+			}
+			class.ranges = append(class.ranges, reRange{lo, hi})
+			continue
+		}
+		class.ranges = append(class.ranges, reRange{lo, lo})
+	}
+}
+
+// parseClassRune reads one rune of a class, escapes like \d are merged into
+// class right away.
+func (p *reParser) parseClassRune(class *reClass) (rune, bool, error) {
+	r := p.next()
+	if r != '\\' {
+		return r, false, nil
+	}
+	if p.eof() {
+		return 0, false, p.errorf("\\ at end of pattern")
+	}
+	if p.peek() == 'b' {
+		p.next()
+		return '\b', false, nil
+	}
+
+	sub, r, err := p.parseClassEscape()
+	if err != nil || sub == nil {
+		return r, false, err
+	}
+
+	if sub.negate {
+		sub.negate = false
+		class.not = append(class.not, sub)
+	} else {
+		class.ranges = append(class.ranges, sub.ranges...)
+		class.tables = append(class.tables, sub.tables...)
+	}
+	return 0, true, nil
+}
+
+// width returns the minimum and maximum length of n in runes, max is -1
+// when it's not bound.
+func (n *reNode) width() (int, int) {
+	switch n.op {
+	case opLiteral, opClass, opAny:
+		return 1, 1
+	case opConcat:
+		min, max := 0, 0
+		for _, sub := range n.subs {
+			lo, hi := sub.width()
+			min += lo
+			if max >= 0 {
+				max += hi
+			}
+			if hi < 0 {
+				max = -1
+			}
+		}
+		return min, max
+	case opAlternate:
+		min, max := -1, 0
+		for _, sub := range n.subs {
+			lo, hi := sub.width()
+			if min < 0 || lo < min {
+				min = lo
+			}
+			if hi < 0 || max < 0 {
+				max = -1
+			} else if hi > max {
+				max = hi
+			}
+		}
+		return min, max
+	case opRepeat:
+		lo, hi := n.subs[0].width()
+		max := -1
+		if n.max >= 0 && hi >= 0 {
+			max = hi * n.max
+		}
+		return lo * n.min, max
+	case opCapture, opAtomic:
+		return n.subs[0].width()
+	case opBackref:
+		return 0, -1
+	}
+	return 0, 0
+}
+
+type backtracker struct {
+	input string
+	caps  []int
+	steps int
+	limit int
+	depth int
+}
+
+func (b *backtracker) step() {
+	b.steps++
+	if b.limit > 0 && b.steps > b.limit {
+		panic(errorStepLimit)
+	}
+}
+
+// single matches a one-rune node at pos and returns its size, 0 if no match.
+func (b *backtracker) single(n *reNode, pos int) int {
+	if pos >= len(b.input) {
+		return 0
+	}
+	r, size := utf8.DecodeRuneInString(b.input[pos:])
+	switch n.op {
+	case opLiteral:
+		if r == n.r || n.fold && equalFoldRune(r, n.r) {
+			return size
+		}
+	case opClass:
+		if n.class.match(r, n.fold) {
+			return size
+		}
+	case opAny:
+		if r != '\n' {
+			return size
+		}
+	}
+	return 0
+}
+
+func (b *backtracker) wordBefore(pos int) bool {
+	if pos == 0 {
+		return false
+	}
+	r, _ := utf8.DecodeLastRuneInString(b.input[:pos])
+	return isWordRune(r)
+}
+
+func (b *backtracker) wordAfter(pos int) bool {
+	if pos >= len(b.input) {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(b.input[pos:])
+	return isWordRune(r)
+}
+
+// match tries n at pos and calls k with the end of every way n matches,
+// in the order of preference, until k returns true.
+func (b *backtracker) match(n *reNode, pos int, k func(int) bool) bool {
+	b.step()
+	if b.depth++; b.depth > maxDepth {
+		panic(errorDepthLimit)
+	}
+	found := b.matchNode(n, pos, k)
+	b.depth--
+	return found
+}
+
+func (b *backtracker) matchNode(n *reNode, pos int, k func(int) bool) bool {
+	switch n.op {
+	case opEmpty:
+		return k(pos)
+
+	case opLiteral, opClass, opAny:
+		size := b.single(n, pos)
+		return size > 0 && k(pos+size)
+
+	case opBegin:
+		return pos == 0 && k(pos)
+
+	case opEnd:
+		return pos == len(b.input) && k(pos)
+
+	case opWordBoundary:
+		return b.wordBefore(pos) != b.wordAfter(pos) && k(pos)
+
+	case opNonWordBoundary:
+		return b.wordBefore(pos) == b.wordAfter(pos) && k(pos)
+
+	case opConcat:
+		return b.concat(n.subs, pos, k)
+
+	case opAlternate:
+		for _, sub := range n.subs {
+			if b.match(sub, pos, k) {
+				return true
+			}
+		}
+		return false
+
+	case opRepeat:
+		if n.subs[0].simple() {
+			return b.repeatSimple(n, pos, k)
+		}
+		return b.repeat(n, pos, 0, k)
+
+	case opCapture:
+		i := 2 * n.group
+		return b.match(n.subs[0], pos, func(end int) bool {
+			start, prevEnd := b.caps[i], b.caps[i+1]
+			b.caps[i], b.caps[i+1] = pos, end
+			if k(end) {
+				return true
+			}
+			b.caps[i], b.caps[i+1] = start, prevEnd
+			return false
+		})
+
+	case opBackref:
+		start, end := b.caps[2*n.group], b.caps[2*n.group+1]
+		if start < 0 {
+			return false
+		}
+		size, ok := len(b.input[start:end]), false
+		if n.fold {
+			size, ok = hasPrefixFold(b.input[pos:], b.input[start:end])
+		} else {
+			ok = strings.HasPrefix(b.input[pos:], b.input[start:end])
+		}
+		return ok && k(pos+size)
+
+	case opLookahead:
+		saved := append([]int(nil), b.caps...)
+		found := b.match(n.subs[0], pos, func(int) bool { return true })
+		if found != n.negate && k(pos) {
+			return true
+		}
+		copy(b.caps, saved)
+		return false
+
+	case opLookbehind:
+		saved := append([]int(nil), b.caps...)
+		found := b.lookbehind(n.subs[0], pos)
+		if found != n.negate && k(pos) {
+			return true
+		}
+		copy(b.caps, saved)
+		return false
+
+	case opAtomic:
+		// the first way the group matches is final, no backtracking into it
+		saved := append([]int(nil), b.caps...)
+		end := -1
+		if !b.match(n.subs[0], pos, func(e int) bool { end = e; return true }) {
+			return false
+		}
+		if k(end) {
+			return true
+		}
+		copy(b.caps, saved)
+		return false
+	}
+
+	return false
+}
+
+func (b *backtracker) concat(subs []*reNode, pos int, k func(int) bool) bool {
+	if len(subs) == 0 {
+		return k(pos)
+	}
+	return b.match(subs[0], pos, func(end int) bool {
+		return b.concat(subs[1:], end, k)
+	})
+}
+
+// simple reports whether n matches at most one way at a position and sets
+// no captures, so repeating it needs no backtracking into the iterations.
+func (n *reNode) simple() bool {
+	switch n.op {
+	case opEmpty, opLiteral, opClass, opAny, opBegin, opEnd, opWordBoundary, opNonWordBoundary, opBackref:
+		return true
+	case opConcat:
+		for _, sub := range n.subs {
+			if !sub.simple() {
+				return false
+			}
+		}
+		return true
+	case opLookahead, opLookbehind, opAtomic:
+		// the first match is final, captures inside are kept on success
+		return !n.subs[0].captures()
+	}
+	return false
+}
+
+func (n *reNode) captures() bool {
+	if n.op == opCapture {
+		return true
+	}
+	for _, sub := range n.subs {
+		if sub.captures() {
+			return true
+		}
+	}
+	return false
+}
+
+// advance matches a simple node at pos and returns its end, -1 if no match.
+func (b *backtracker) advance(n *reNode, pos int) int {
+	switch n.op {
+	case opLiteral, opClass, opAny:
+		b.step()
+		if size := b.single(n, pos); size > 0 {
+			return pos + size
+		}
+		return -1
+	}
+
+	end := -1
+	b.match(n, pos, func(e int) bool { end = e; return true })
+	return end
+}
+
+// repeatSimple repeats a simple node in a loop instead of recursion, so the
+// stack doesn't grow with the number of iterations.
+func (b *backtracker) repeatSimple(n *reNode, pos int, k func(int) bool) bool {
+	sub := n.subs[0]
+
+	if !n.greedy {
+		for count := 0; ; count++ {
+			if count >= n.min && k(pos) {
+				return true
+			}
+			if count == n.max {
+				return false
+			}
+			end := b.advance(sub, pos)
+			// an empty iteration past min gives nothing new
+			if end < 0 || end == pos && count >= n.min {
+				return false
+			}
+			pos = end
+		}
+	}
+
+	ends := []int{pos}
+	for n.max < 0 || len(ends) <= n.max {
+		end := b.advance(sub, pos)
+		if end < 0 || end == pos && len(ends) > n.min {
+			break
+		}
+		pos = end
+		ends = append(ends, pos)
+	}
+	for count := len(ends) - 1; count >= n.min; count-- {
+		if k(ends[count]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *backtracker) repeat(n *reNode, pos, count int, k func(int) bool) bool {
+	if count < n.min {
+		return b.match(n.subs[0], pos, func(end int) bool {
+			return b.repeat(n, end, count+1, k)
+		})
+	}
+	if count == n.max {
+		return k(pos)
+	}
+
+	more := func() bool {
+		return b.match(n.subs[0], pos, func(end int) bool {
+			// an empty iteration would repeat forever
+			if end == pos {
+				return false
+			}
+			return b.repeat(n, end, count+1, k)
+		})
+	}
+	if n.greedy {
+		return more() || k(pos)
+	}
+	return k(pos) || more()
+}
+
+// lookbehind looks for a match of n that ends at pos, starts are limited by
+// the width of n when it's bound.
+func (b *backtracker) lookbehind(n *reNode, pos int) bool {
+	minWidth, maxWidth := n.width()
+
+	start, runes := pos, 0
+	for runes < minWidth && start > 0 {
+		_, size := utf8.DecodeLastRuneInString(b.input[:start])
+		start -= size
+		runes++
+	}
+	if runes < minWidth {
+		return false
+	}
+
+	for {
+		if b.match(n, start, func(end int) bool { return end == pos }) {
+			return true
+		}
+		if start == 0 || maxWidth >= 0 && runes >= maxWidth {
+			return false
+		}
+		_, size := utf8.DecodeLastRuneInString(b.input[:start])
+		start -= size
+		runes++
+	}
+}
+
+// perlMatcher is the -P matcher. It panics with errorStepLimit or
+// errorDepthLimit, grep turns that into an error of the file.
+type perlMatcher struct {
+	prog     *reNode
+	groups   int
+	anchored bool
+	limit    int
+
+	// first is the literal every match starts with, required are literals
+	// every match has, they let search skip hopeless starts and lines
+	first    string
+	required []string
+}
+
+func newPerlMatcher(pattern string, limit int) (*perlMatcher, error) {
+	prog, p, err := parsePerl(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &perlMatcher{prog: prog, groups: p.groups, limit: limit}
+	first := prog
+	for first.op == opConcat && len(first.subs) > 0 {
+		first = first.subs[0]
+	}
+	m.anchored = first.op == opBegin
+	if first.op == opLiteral && !first.fold {
+		m.first = string(first.r)
+	}
+	for _, r := range prog.required(nil) {
+		m.required = append(m.required, string(r))
+	}
+
+	return m, nil
+}
+
+// required appends runes that are in every match of n, case sensitive only.
+func (n *reNode) required(runes []rune) []rune {
+	switch n.op {
+	case opLiteral:
+		if !n.fold {
+			runes = append(runes, n.r)
+		}
+	case opConcat:
+		for _, sub := range n.subs {
+			runes = sub.required(runes)
+		}
+	case opCapture, opAtomic:
+		runes = n.subs[0].required(runes)
+	case opRepeat:
+		if n.min > 0 {
+			runes = n.subs[0].required(runes)
+		}
+	}
+	return runes
+}
+
+// search returns the leftmost match starting at from or later.
+func (m *perlMatcher) search(line string, from int) (int, int) {
+	b := &backtracker{
+		input: line,
+		caps:  make([]int, 2*(m.groups+1)),
+		limit: m.limit,
+	}
+
+	for _, r := range m.required {
+		if !strings.Contains(line[from:], r) {
+			return -1, -1
+		}
+	}
+
+	for start := from; start <= len(line); {
+		if m.first != "" {
+			i := strings.Index(line[start:], m.first)
+			if i < 0 || m.anchored && i > 0 {
+				break
+			}
+			start += i
+		}
+
+		for i := range b.caps {
+			b.caps[i] = -1
+		}
+
+		end := -1
+		if b.match(m.prog, start, func(e int) bool { end = e; return true }) {
+			return start, end
+		}
+		if m.anchored || start == len(line) {
+			break
+		}
+		_, size := utf8.DecodeRuneInString(line[start:])
+		start += size
+	}
+	return -1, -1
+}
+
+func (m *perlMatcher) match(line string) bool {
+	start, _ := m.search(line, 0)
+	return start >= 0
+}
+
+func (m *perlMatcher) find(line string) [][]int {
+	var spans [][]int
+	for from := 0; from <= len(line); {
+		start, end := m.search(line, from)
+		if start < 0 {
+			break
+		}
+		spans = append(spans, []int{start, end})
+		if m.anchored {
+			break
+		}
+
+		from = end
+		if end == start {
+			if end == len(line) {
+				break
+			}
+			_, size := utf8.DecodeRuneInString(line[end:])
+			from += size
+		}
+	}
+	return spans
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPerlMatcher(t *testing.T) {
+	testTable := []struct {
+		name    string
+		pattern string
+		setup   func()
+		line    string
+		result  [][]int
+	}{
+		{name: "literal", pattern: "ab", line: "xabyab", result: [][]int{{1, 3}, {4, 6}}},
+		{name: "leftmost first alternation", pattern: "a|ab", line: "ab", result: [][]int{{0, 1}}},
+		{name: "greedy", pattern: "a.*b", line: "aXbYb", result: [][]int{{0, 5}}},
+		{name: "lazy", pattern: "a.*?b", line: "aXbYb", result: [][]int{{0, 3}}},
+		{name: "literal brace", pattern: `a{2`, line: "a{2", result: [][]int{{0, 3}}},
+		{name: "counted", pattern: `\d{2,3}`, line: "1 12 12345", result: [][]int{{2, 4}, {5, 8}, {8, 10}}},
+		{name: "backreference", pattern: `(\w)\1`, line: "abccd", result: [][]int{{2, 4}}},
+		{name: "named backreference", pattern: `(?<q>['"]).*?\k<q>`, line: `say "it's" ok`, result: [][]int{{4, 10}}},
+		{name: "backreference ignore case", pattern: `(a)\1`, setup: func() { ignoreCase = true }, line: "xaA", result: [][]int{{1, 3}}},
+		{name: "lookahead", pattern: `\w+(?=:)`, line: "key: value", result: [][]int{{0, 3}}},
+		{name: "negative lookahead", pattern: `foo(?!bar)`, line: "foobar foobaz", result: [][]int{{7, 10}}},
+		{name: "lookbehind", pattern: `(?<=\$)\d+`, line: "a 10 $20", result: [][]int{{6, 8}}},
+		{name: "negative lookbehind", pattern: `(?<!-)\b\d+`, line: "-1 2", result: [][]int{{3, 4}}},
+		{name: "variable lookbehind", pattern: `(?<=ab+)c`, line: "ac abbbc", result: [][]int{{7, 8}}},
+		{name: "atomic group", pattern: `(?>a+)b|a+c`, line: "aaac", result: [][]int{{0, 4}}},
+		{name: "possessive", pattern: `a++a`, line: "aaa", result: nil},
+		{name: "class", pattern: `[^\d\s-]+`, line: "12 ab-cd", result: [][]int{{3, 5}, {6, 8}}},
+		{name: "posix class", pattern: `[[:upper:]]+`, line: "abCDe", result: [][]int{{2, 4}}},
+		{name: "unicode", pattern: `\p{Cyrillic}+`, line: "go привет", result: [][]int{{3, 15}}},
+		{name: "unicode word", pattern: `\w+`, line: "мир!", result: [][]int{{0, 6}}},
+		{name: "inline flag", pattern: `a(?i)b`, line: "aB AB", result: [][]int{{0, 2}}},
+		{name: "quoted", pattern: `\Q.*\E`, line: "a.*", result: [][]int{{1, 3}}},
+		{name: "anchors", pattern: `^a|b$`, line: "aab", result: [][]int{{0, 1}, {2, 3}}},
+		{name: "empty match", pattern: `x*`, line: "ab", result: [][]int{{0, 0}, {1, 1}, {2, 2}}},
+		{name: "word", pattern: `foo`, setup: func() { wordRegexp = true }, line: "foobar foo_ foo", result: [][]int{{12, 15}}},
+		{name: "line", pattern: `a+`, setup: func() { lineRegexp = true }, line: "aaa", result: [][]int{{0, 3}}},
+		{name: "line no match", pattern: `a+`, setup: func() { lineRegexp = true }, line: "aab", result: nil},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			resetFlags()
+			perl = true
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+
+			m, err := newMatcher([]string{testCase.pattern})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			result := m.find(testCase.line)
+			if !reflect.DeepEqual(result, testCase.result) {
+				t.Errorf("Incorrect spans: expect %v, got %v", testCase.result, result)
+			}
+			if m.match(testCase.line) != (testCase.result != nil) {
+				t.Errorf("Incorrect match: expect %v", testCase.result != nil)
+			}
+		})
+	}
+}
+
+func TestPerlMatcherInvalid(t *testing.T) {
+	for _, pattern := range []string{"a(", "a)", "[a", `\2(a)`, `\k<x>`, "*a", "[b-a]", `\p{Nope}`, "(?z)", `a\`} {
+		resetFlags()
+		perl = true
+		if _, err := newMatcher([]string{pattern}); !errors.Is(err, errorInvalidRegexp) {
+			t.Errorf("Expected %v for %q, got %v", errorInvalidRegexp, pattern, err)
+		}
+	}
+
+	resetFlags()
+	perl = true
+	if _, err := newMatcher([]string{"a", "b"}); !errors.Is(err, errorPerlPatterns) {
+		t.Errorf("Expected %v, got %v", errorPerlPatterns, err)
+	}
+	fixed = true
+	if _, err := newMatcher([]string{"a"}); !errors.Is(err, errorMatchers) {
+		t.Errorf("Expected %v, got %v", errorMatchers, err)
+	}
+}
+
+func TestGrepStepLimit(t *testing.T) {
+	resetFlags()
+	perl = true
+	m, err := newMatcher([]string{`(a+)+[bc]`})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	input := "aab\n" + strings.Repeat("a", 40) + "\nab\n"
+	builder := strings.Builder{}
+	_, err = grep(&builder, "input", strings.NewReader(input), m)
+	if !errors.Is(err, errorStepLimit) {
+		t.Errorf("Expected %v, got %v", errorStepLimit, err)
+	}
+	if builder.String() != "aab\n" {
+		t.Errorf("Incorrect result: expect %q, got %q", "aab\n", builder.String())
+	}
+}
+
+func perlFind(t *testing.T, pattern, line string) [][]int {
+	t.Helper()
+	resetFlags()
+	perl = true
+	m, err := newMatcher([]string{pattern})
+	if err != nil {
+		t.Fatalf("Unexpected error for %q: %v", pattern, err)
+	}
+	return m.find(line)
+}
+
+func TestPerlBackreferences(t *testing.T) {
+	testTable := []struct {
+		pattern string
+		line    string
+		result  [][]int
+	}{
+		{pattern: `(a)(b)\2\1`, line: "xabba", result: [][]int{{1, 5}}},
+		{pattern: `((a)b)\2`, line: "aba abb", result: [][]int{{0, 3}}},
+		{pattern: `(\w)\1+`, line: "aaab bb", result: [][]int{{0, 3}, {5, 7}}},
+		{pattern: `(?:(a)|b)\1`, line: "bb aa", result: [][]int{{3, 5}}},
+		{pattern: `(a)?b\1`, line: "b ab aba", result: [][]int{{5, 8}}},
+		{pattern: `(a*)b\1`, line: "aabaa", result: [][]int{{0, 5}}},
+		{pattern: `(a*)b\1$`, line: "aaba", result: [][]int{{1, 4}}},
+		{pattern: `(\w+) \1`, line: "the the cat", result: [][]int{{0, 7}}},
+		{pattern: `\b(\w+) \1\b`, line: "then the end", result: nil},
+		{pattern: `(?<word>\w+)-\k<word>`, line: "go-go go-to", result: [][]int{{0, 5}}},
+		{pattern: `(\d)(?:x\1)+`, line: "1x1x1x2", result: [][]int{{0, 5}}},
+		{pattern: `(.)(?!\1).`, line: "aab", result: [][]int{{1, 3}}},
+		{pattern: `(ё)\1`, line: "ёёж", result: [][]int{{0, 4}}},
+	}
+
+	for _, testCase := range testTable {
+		if result := perlFind(t, testCase.pattern, testCase.line); !reflect.DeepEqual(result, testCase.result) {
+			t.Errorf("Incorrect result for %q on %q: expect %v, got %v", testCase.pattern, testCase.line, testCase.result, result)
+		}
+	}
+}
+
+func TestPerlLookaround(t *testing.T) {
+	testTable := []struct {
+		pattern string
+		line    string
+		result  [][]int
+	}{
+		{pattern: `a(?=b)`, line: "ac ab", result: [][]int{{3, 4}}},
+		{pattern: `a(?!b)`, line: "ab ac", result: [][]int{{3, 4}}},
+		{pattern: `a(?!$)`, line: "aa", result: [][]int{{0, 1}}},
+		{pattern: `(?=(\w+))\1:`, line: "key:", result: [][]int{{0, 4}}},
+		{pattern: `(?=\d{3})\d`, line: "12 345", result: [][]int{{3, 4}}},
+		{pattern: `(?<=a)b`, line: "b ab", result: [][]int{{3, 4}}},
+		{pattern: `(?<!a)b`, line: "ab cb b", result: [][]int{{4, 5}, {6, 7}}},
+		{pattern: `(?<=^)a`, line: "aa", result: [][]int{{0, 1}}},
+		{pattern: `(?<=ab|c)d`, line: "ad abd cd", result: [][]int{{5, 6}, {8, 9}}},
+		{pattern: `(?<=\d{2})x`, line: "1x 12x", result: [][]int{{5, 6}}},
+		{pattern: `(?<=a.*)b`, line: "b xab", result: [][]int{{4, 5}}},
+		{pattern: `(?<=ё)ж`, line: "жёж", result: [][]int{{4, 6}}},
+		{pattern: `(?<=(?<!x)a)b`, line: "xab ab", result: [][]int{{5, 6}}},
+		{pattern: `(?<=(a))b\1`, line: "aba", result: [][]int{{1, 3}}},
+		{pattern: `\b(?!un)\w+`, line: "undo do", result: [][]int{{5, 7}}},
+		{pattern: `^(?=.*\d)(?=.*[a-z]).+$`, line: "abc1", result: [][]int{{0, 4}}},
+		{pattern: `^(?=.*\d)(?=.*[a-z]).+$`, line: "abcd", result: nil},
+	}
+
+	for _, testCase := range testTable {
+		if result := perlFind(t, testCase.pattern, testCase.line); !reflect.DeepEqual(result, testCase.result) {
+			t.Errorf("Incorrect result for %q on %q: expect %v, got %v", testCase.pattern, testCase.line, testCase.result, result)
+		}
+	}
+}
+
+func TestPerlRepeat(t *testing.T) {
+	testTable := []struct {
+		pattern string
+		line    string
+		result  [][]int
+	}{
+		{pattern: `(?:ab){2}`, line: "ababab", result: [][]int{{0, 4}}},
+		{pattern: `(?:ab){2,}`, line: "ab ababab", result: [][]int{{3, 9}}},
+		{pattern: `(?:ab){1,2}?`, line: "abab", result: [][]int{{0, 2}, {2, 4}}},
+		{pattern: `(?:ab)+?c`, line: "ababc", result: [][]int{{0, 5}}},
+		{pattern: `(?:ab)*b`, line: "abab", result: [][]int{{1, 2}, {3, 4}}},
+		{pattern: `(?:ab)+$`, line: "xabab", result: [][]int{{1, 5}}},
+		{pattern: `(?:a?)*b`, line: "b", result: [][]int{{0, 1}}},
+		{pattern: `(?:x?){3}y`, line: "y", result: [][]int{{0, 1}}},
+		{pattern: `(?:(?=\d)\w)+`, line: "a12b", result: [][]int{{1, 3}}},
+		{pattern: `(?:a|ab)+c`, line: "abac", result: [][]int{{0, 4}}},
+		{pattern: `(ab)+\1`, line: "ababab", result: [][]int{{0, 6}}},
+		{pattern: `(?>ab|a)+c`, line: "abac", result: [][]int{{0, 4}}},
+		{pattern: `(?:a++b)+`, line: "aabab", result: [][]int{{0, 5}}},
+	}
+
+	for _, testCase := range testTable {
+		if result := perlFind(t, testCase.pattern, testCase.line); !reflect.DeepEqual(result, testCase.result) {
+			t.Errorf("Incorrect result for %q on %q: expect %v, got %v", testCase.pattern, testCase.line, testCase.result, result)
+		}
+	}
+}
+
+func TestNodeSimple(t *testing.T) {
+	testTable := []struct {
+		pattern string
+		result  bool
+	}{
+		{pattern: `a`, result: true},
+		{pattern: `ab\d.`, result: true},
+		{pattern: `^a\b$`, result: true},
+		{pattern: `(?=a)b(?<!c)`, result: true},
+		{pattern: `(?>a|ab)`, result: true},
+		{pattern: `(?=(a))`, result: false},
+		{pattern: `a|b`, result: false},
+		{pattern: `a+`, result: false},
+		{pattern: `(a)`, result: false},
+		{pattern: `a(?:b|c)`, result: false},
+	}
+
+	for _, testCase := range testTable {
+		node, _, err := parsePerl(testCase.pattern)
+		if err != nil {
+			t.Fatalf("Unexpected error for %q: %v", testCase.pattern, err)
+		}
+		if result := node.simple(); result != testCase.result {
+			t.Errorf("Incorrect result for %q: expect %v, got %v", testCase.pattern, testCase.result, result)
+		}
+	}
+}
+
+func TestNodeWidth(t *testing.T) {
+	testTable := []struct {
+		pattern  string
+		min, max int
+	}{
+		{pattern: `abc`, min: 3, max: 3},
+		{pattern: `ab|c`, min: 1, max: 2},
+		{pattern: `a{2,4}`, min: 2, max: 4},
+		{pattern: `ab*`, min: 1, max: -1},
+		{pattern: `(?=abc)a`, min: 1, max: 1},
+		{pattern: `(a)\1`, min: 1, max: -1},
+		{pattern: `^\b$`, min: 0, max: 0},
+	}
+
+	for _, testCase := range testTable {
+		node, _, err := parsePerl(testCase.pattern)
+		if err != nil {
+			t.Fatalf("Unexpected error for %q: %v", testCase.pattern, err)
+		}
+		if min, max := node.width(); min != testCase.min || max != testCase.max {
+			t.Errorf("Incorrect result for %q: expect %d-%d, got %d-%d", testCase.pattern, testCase.min, testCase.max, min, max)
+		}
+	}
+}
+
+func TestPerlStepLimitOff(t *testing.T) {
+	resetFlags()
+	perl, stepLimit = true, 0
+	m, err := newMatcher([]string{`(a+)+[bc]`})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// about 2^18 steps, more than a small limit but fine without one
+	input := strings.Repeat("a", 18) + "\n" + strings.Repeat("a", 18) + "b\n"
+	builder := strings.Builder{}
+	if _, err := grep(&builder, "input", strings.NewReader(input), m); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expect := strings.Repeat("a", 18) + "b\n"; builder.String() != expect {
+		t.Errorf("Incorrect result: expect %q, got %q", expect, builder.String())
+	}
+
+	resetFlags()
+	perl, stepLimit = true, 1000
+	m, err = newMatcher([]string{`(a+)+[bc]`})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := grep(&builder, "input", strings.NewReader(input), m); !errors.Is(err, errorStepLimit) {
+		t.Errorf("Expected %v, got %v", errorStepLimit, err)
+	}
+}
+
+func TestPerlDeepInput(t *testing.T) {
+	testTable := []struct {
+		name    string
+		pattern string
+		line    string
+		result  bool
+		err     error
+	}{
+		{name: "simple repeat", pattern: `(?:ab)+$`, line: strings.Repeat("ab", 1000000), result: true},
+		{name: "simple repeat no match", pattern: `^(?:ab)+$`, line: strings.Repeat("ab", 1000000) + "a", result: false},
+		{name: "lazy simple repeat", pattern: `^(?:ab)+?$`, line: strings.Repeat("ab", 100000), result: true},
+		{name: "class repeat", pattern: `^\w+$`, line: strings.Repeat("ж", 1000000), result: true},
+		{name: "lookaround repeat", pattern: `^(?:(?=a)a(?<=a)b)+$`, line: strings.Repeat("ab", 200000), result: true},
+		{name: "capture repeat", pattern: `(ab)+$`, line: strings.Repeat("ab", 10000), result: true},
+		{name: "alternation repeat", pattern: `^(?:a|b)+$`, line: strings.Repeat("ab", 10000), result: true},
+		{name: "capture repeat too deep", pattern: `(ab)+$`, line: strings.Repeat("ab", maxDepth), err: errorDepthLimit},
+		{name: "alternation repeat too deep", pattern: `^(?:a|b)+$`, line: strings.Repeat("ab", maxDepth), err: errorDepthLimit},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			resetFlags()
+			perl, stepLimit = true, 0
+			m, err := newMatcher([]string{testCase.pattern})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			builder := strings.Builder{}
+			stats, err := grep(&builder, "input", strings.NewReader(testCase.line+"\n"), m)
+			if !errors.Is(err, testCase.err) {
+				t.Fatalf("Expected %v, got %v", testCase.err, err)
+			}
+			if testCase.err == nil && (stats.selected > 0) != testCase.result {
+				t.Errorf("Incorrect result: expect %v, got %v", testCase.result, stats.selected > 0)
+			}
+		})
+	}
+}
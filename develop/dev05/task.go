@@ -1,13 +1,25 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 /*
@@ -34,137 +46,1613 @@ var (
 	context    int
 	count      bool
 	ignoreCase bool
+	smartCase  bool
 	invert     bool
 	fixed      bool
+	perl       bool
+	stepLimit  int
 	lineNum    bool
 
-	searchWord string
-	fileNames  []string
+	groupSeparator   string
+	noGroupSeparator bool
+
+	onlyMatching      bool
+	colorMode         string
+	color             bool
+	byteOffset        bool
+	filesWithMatches  bool
+	filesWithoutMatch bool
+	maxCount          int
+	quiet             bool
+	jsonOutput        bool
+	decompress        bool
+
+	recursive   bool
+	dereference bool
+	includes    globList
+	excludes    globList
+	excludeDirs globList
+	gitignore   bool
+
+	jobs             int
+	withFileNameFlag bool
+	noFileNameFlag   bool
+	withFileName     bool
+
+	patterns     stringList
+	patternFiles stringList
+	wordRegexp   bool
+	lineRegexp   bool
+
+	fileNames []string
 )
 
 var (
 	errorNotEnoughArgs = errors.New("Not enough args")
 	errorFileNotFound  = errors.New("No such file or directory")
 	errorInvalidRegexp = errors.New("Invalid regular expression")
+	errorIsDirectory   = errors.New("Is a directory")
+	errorInvalidColor  = errors.New("Invalid argument for --color, valid: always, never, auto")
+	errorJSONMode      = errors.New("--json can't be used with -c, -l, -L, -o or -q")
+	errorMatchers      = errors.New("Conflicting matchers specified, -F and -P")
+	errorPerlPatterns  = errors.New("-P supports only a single pattern")
+	errorStepLimit     = errors.New("Exceeded the backtracking step limit of -P")
+	errorDepthLimit    = errors.New("Exceeded the backtracking depth limit of -P")
+)
+
+const stdinName = "(standard input)"
+
+// GNU grep default colors
+const (
+	colorMatch   = "\x1b[01;31m\x1b[K"
+	colorFile    = "\x1b[35m\x1b[K"
+	colorLineNum = "\x1b[32m\x1b[K"
+	colorSep     = "\x1b[36m\x1b[K"
+	colorReset   = "\x1b[m\x1b[K"
 )
 
+// stringList collects a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set .
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// globList collects a repeatable glob flag.
+type globList []string
+
+func (g *globList) String() string {
+	return strings.Join(*g, ",")
+}
+
+// Set .
+func (g *globList) Set(value string) error {
+	if _, err := filepath.Match(value, ""); err != nil {
+		return err
+	}
+	*g = append(*g, value)
+	return nil
+}
+
+func (g globList) match(name string) bool {
+	for _, pattern := range g {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
 func init() {
 	flag.IntVar(&after, "A", 0, "print +N lines after a match")
 	flag.IntVar(&before, "B", 0, "print +N lines before a match")
 	flag.IntVar(&context, "C", 0, "print ±N lines around the match")
 	flag.BoolVar(&count, "c", false, "print only a count of selected lines")
 	flag.BoolVar(&ignoreCase, "i", false, "ignore case differences")
+	flag.BoolVar(&smartCase, "S", false, "ignore case unless the pattern has upper case letters")
 	flag.BoolVar(&invert, "v", false, "select non-matching lines")
 	flag.BoolVar(&fixed, "F", false, "exact match with a string, not a pattern")
-	flag.BoolVar(&lineNum, "n", false, "sort by numeric value, taking into account suffixes")
+	flag.BoolVar(&perl, "P", false, "PCRE-like patterns with backreferences and lookaround, matched by backtracking")
+	flag.IntVar(&stepLimit, "step-limit", 1000000, "fail a file with -P when matching a line takes more than N steps, 0 is no limit")
+	flag.Var(&patterns, "e", "use PATTERN for matching, may be repeated")
+	flag.Var(&patternFiles, "f", "take patterns from FILE, one per line")
+	flag.BoolVar(&wordRegexp, "w", false, "match only whole words")
+	flag.BoolVar(&lineRegexp, "x", false, "match only whole lines")
+	flag.BoolVar(&lineNum, "n", false, "print line number with output lines")
+	flag.StringVar(&groupSeparator, "group-separator", "--", "print SEP between groups of context lines")
+	flag.BoolVar(&noGroupSeparator, "no-group-separator", false, "do not print a separator between groups of context lines")
 
-	flag.Parse()
-	searchWord = flag.Arg(0)
-	fileNames = flag.Args()
+	flag.BoolVar(&onlyMatching, "o", false, "print only the matched parts of lines")
+	flag.StringVar(&colorMode, "color", "never", "highlight matches: always, never or auto")
+	flag.BoolVar(&byteOffset, "b", false, "print the byte offset of lines, or of matches with -o")
+	flag.BoolVar(&filesWithMatches, "l", false, "print only names of files with selected lines")
+	flag.BoolVar(&filesWithoutMatch, "L", false, "print only names of files without selected lines")
+	flag.IntVar(&maxCount, "m", 0, "stop reading a file after N selected lines")
+	flag.BoolVar(&jsonOutput, "json", false, "print results as JSON Lines events: begin, match, context, end, summary")
+	flag.BoolVar(&quiet, "q", false, "print nothing, exit with 0 on the first selected line")
+
+	flag.BoolVar(&decompress, "z", false, "search inside gzip and bzip2 files, detected by magic bytes")
+
+	flag.BoolVar(&recursive, "r", false, "search directories recursively, skipping symlinks found inside")
+	flag.BoolVar(&dereference, "R", false, "like -r, but follow all symlinks")
+	flag.Var(&includes, "include", "search only files whose base name matches GLOB")
+	flag.Var(&excludes, "exclude", "skip files whose base name matches GLOB")
+	flag.Var(&excludeDirs, "exclude-dir", "skip directories whose base name matches GLOB")
+	flag.IntVar(&jobs, "j", 1, "search N files concurrently, output keeps the order of files")
+	flag.BoolVar(&withFileNameFlag, "H", false, "print the file name for each match")
+	flag.BoolVar(&noFileNameFlag, "h", false, "never print file names")
+	flag.BoolVar(&gitignore, "gitignore", false, "skip files ignored by .gitignore files and .git directories")
 }
 
 func main() {
-	if len(fileNames) < 2 {
-		fmt.Println(errorNotEnoughArgs)
+	os.Exit(run())
+}
+
+// fileStats are results of searching one file.
+type fileStats struct {
+	selected int
+	// matches are counted only when spans are looked for, with --json
+	matches int
+	bytes   int64
+}
+
+// summary collects results of all files for the exit status and --json.
+type summary struct {
+	selected bool
+	failed   bool
+
+	total             fileStats
+	searches          int
+	searchesWithMatch int
+}
+
+func (s *summary) add(stats fileStats, err error) {
+	if err != nil {
+		s.failed = true
 		return
 	}
-	fileNames = fileNames[1:]
+
+	s.searches++
+	if stats.selected > 0 {
+		s.selected = true
+		s.searchesWithMatch++
+	}
+	s.total.selected += stats.selected
+	s.total.matches += stats.matches
+	s.total.bytes += stats.bytes
+}
+
+// status is 0 if a line is selected, 1 if none and 2 on error,
+// an error doesn't matter with -q once a line is selected.
+func (s summary) status() int {
+	switch {
+	case s.failed && !(quiet && s.selected):
+		return 2
+	case s.selected:
+		return 0
+	default:
+		return 1
+	}
+}
+
+func run() int {
+	start := time.Now()
+	flag.Parse()
+	fileNames = flag.Args()
+
+	// the first argument is the pattern unless -e or -f is given
+	if len(patterns) == 0 && len(patternFiles) == 0 {
+		if len(fileNames) < 1 {
+			fmt.Fprintln(os.Stderr, errorNotEnoughArgs)
+			return 2
+		}
+		patterns = stringList{fileNames[0]}
+		fileNames = fileNames[1:]
+	}
+
+	all, err := collectPatterns(patterns, patternFiles)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
 
 	if context > 0 {
 		after = context
 		before = context
 	}
 
-	if ignoreCase {
-		searchWord = strings.ToLower(searchWord)
+	if smartCase {
+		ignoreCase = true
+		for _, p := range all {
+			if fixed && hasUpper(p) || !fixed && patternHasUpper(p) {
+				ignoreCase = false
+				break
+			}
+		}
 	}
 
-	var check func(line string) bool
-	if fixed {
-		check = func(line string) bool {
-			return strings.Contains(line, searchWord) != invert
+	switch colorMode {
+	case "always":
+		color = true
+	case "auto":
+		color = isTerminal(os.Stdout)
+	case "never":
+	default:
+		fmt.Fprintln(os.Stderr, errorInvalidColor)
+		return 2
+	}
+
+	if jsonOutput && (count || quiet || filesWithMatches || filesWithoutMatch || onlyMatching) {
+		fmt.Fprintln(os.Stderr, errorJSONMode)
+		return 2
+	}
+
+	m, err := newMatcher(all)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if dereference {
+		recursive = true
+	}
+	withFileName = recursive || len(fileNames) > 1
+	if withFileNameFlag {
+		withFileName = true
+	}
+	if noFileNameFlag {
+		withFileName = false
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	report := func(err error) {
+		out.Flush()
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	if len(fileNames) == 0 && recursive {
+		fileNames = []string{"."}
+	}
+
+	var sum summary
+	switch {
+	case len(fileNames) == 0:
+		stats, err := grepInput(out, stdinName, os.Stdin, m)
+		if err != nil {
+			report(err)
 		}
-	} else {
-		re, err := regexp.Compile(searchWord)
+		sum.add(stats, err)
+
+	case jobs > 1:
+		sum = searchParallel(out, fileNames, jobs, m)
+
+	default:
+		listFiles(fileNames, func(path string, err error) {
+			// with -q the rest of the files doesn't change the result
+			if quiet && sum.selected {
+				return
+			}
+
+			var stats fileStats
+			if err == nil {
+				stats, err = search(out, path, m)
+			}
+			if err != nil {
+				report(err)
+			}
+			sum.add(stats, err)
+		})
+	}
+
+	if jsonOutput {
+		writeJSONSummary(out, sum, time.Since(start))
+	}
+	return sum.status()
+}
+
+// collectPatterns joins -e patterns and lines of -f files, a pattern with
+// newlines is a list of patterns like in GNU grep.
+func collectPatterns(patterns, patternFiles []string) ([]string, error) {
+	var all []string
+	for _, pattern := range patterns {
+		all = append(all, strings.Split(pattern, "\n")...)
+	}
+
+	for _, fileName := range patternFiles {
+		input, err := os.Open(fileName)
 		if err != nil {
-			fmt.Printf("%s: %s: %s\n", searchWord, errorInvalidRegexp.Error(), err.Error())
+			return nil, fmt.Errorf("%w: %s", errorFileNotFound, fileName)
+		}
+
+		scanner := bufio.NewScanner(input)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			all = append(all, scanner.Text())
+		}
+		input.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("Error in collectPatterns with %s: %w", fileName, err)
+		}
+	}
+
+	return all, nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func hasUpper(s string) bool {
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// patternHasUpper is hasUpper for a regular expression: letters of escapes
+// like \S, \D, \W, \B, hex digits of \x and \p{..}/\P{..} class names are
+// syntax, not text, so they don't turn smart case off.
+func patternHasUpper(p string) bool {
+	for i := 0; i < len(p); i++ {
+		if p[i] != '\\' {
+			r, size := utf8.DecodeRuneInString(p[i:])
+			if unicode.IsUpper(r) {
+				return true
+			}
+			i += size - 1
+			continue
+		}
+		if i++; i == len(p) {
+			break
+		}
+
+		switch p[i] {
+		case 'p', 'P', 'x':
+			// \pL, \p{Greek}, \xFF, \x{10FFFF}
+			switch {
+			case i+1 < len(p) && p[i+1] == '{':
+				if end := strings.IndexByte(p[i:], '}'); end >= 0 {
+					i += end
+				} else {
+					i = len(p)
+				}
+			case p[i] == 'x':
+				for n := 0; n < 2 && i+1 < len(p) && isHexDigit(p[i+1]); n++ {
+					i++
+				}
+			case i+1 < len(p):
+				i++
+			}
+		case 'Q':
+			// \Q...\E quotes text, letters inside are text
+			text := p[i+1:]
+			if end := strings.Index(text, `\E`); end >= 0 {
+				text = text[:end]
+			}
+			if hasUpper(text) {
+				return true
+			}
+			i += len(text) + len(`\E`)
+		default:
+			// the escaped character itself is skipped, \. or \S alike
+			_, size := utf8.DecodeRuneInString(p[i:])
+			i += size - 1
+		}
+	}
+	return false
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// matcher finds the pattern in a line. Case is ignored by the matcher
+// itself, the line is never changed, so output keeps the original text.
+type matcher interface {
+	match(line string) bool
+	// find returns [start, end) byte offsets of non-overlapping matches.
+	find(line string) [][]int
+}
+
+// wordClass matches a non-word constituent: anything but letters, digits and '_'.
+const wordClass = `[^\pL\pN_]`
+
+type regexpMatcher struct {
+	re *regexp.Regexp
+	// with -w re finds the pattern as group 1 at the line start or after a
+	// non-word rune, mid is the same without the line start
+	word bool
+	mid  *regexp.Regexp
+}
+
+func (m regexpMatcher) match(line string) bool {
+	return m.re.MatchString(line)
+}
+
+func (m regexpMatcher) find(line string) [][]int {
+	if !m.word {
+		return m.re.FindAllStringIndex(line, -1)
+	}
+
+	var spans [][]int
+	loc := m.re.FindStringSubmatchIndex(line)
+	for loc != nil {
+		start, end := loc[2], loc[3]
+		spans = append(spans, []int{start, end})
+		if end == start {
+			if end == len(line) {
+				break
+			}
+			_, size := utf8.DecodeRuneInString(line[end:])
+			end += size
+		}
+		if end >= len(line) {
+			break
+		}
+
+		// the rune before the rest is passed too, mid checks it's not a word one
+		_, size := utf8.DecodeLastRuneInString(line[:end])
+		from := end - size
+		loc = m.mid.FindStringSubmatchIndex(line[from:])
+		for i := range loc {
+			loc[i] += from
+		}
+	}
+	return spans
+}
+
+type fixedMatcher struct {
+	pattern string
+	fold    bool
+	word    bool
+	line    bool
+	// ac is set for more than one pattern
+	ac *ahoCorasick
+}
+
+func (m fixedMatcher) index(s string) (int, int) {
+	if m.fold {
+		return indexFold(s, m.pattern)
+	}
+	i := strings.Index(s, m.pattern)
+	if i < 0 {
+		return -1, -1
+	}
+	return i, i + len(m.pattern)
+}
+
+// scan calls fn for every occurrence of the patterns, overlapping ones
+// included, until fn returns false.
+func (m fixedMatcher) scan(line string, fn func(start, end int) bool) {
+	if m.ac != nil {
+		m.ac.scan(line, fn)
+		return
+	}
+
+	for offset := 0; offset <= len(line); {
+		start, end := m.index(line[offset:])
+		if start < 0 || !fn(offset+start, offset+end) {
 			return
 		}
+		offset += start
+		if offset == len(line) {
+			return
+		}
+		_, size := utf8.DecodeRuneInString(line[offset:])
+		offset += size
+	}
+}
 
-		check = func(line string) bool {
-			return re.MatchString(line) != invert
+// accept checks -w and -x for an occurrence.
+func (m fixedMatcher) accept(line string, start, end int) bool {
+	if m.line {
+		return start == 0 && end == len(line)
+	}
+	if m.word {
+		return wordBoundary(line, start, end)
+	}
+	return true
+}
+
+func (m fixedMatcher) match(line string) bool {
+	if m.ac == nil && !m.word && !m.line {
+		start, _ := m.index(line)
+		return start >= 0
+	}
+
+	found := false
+	m.scan(line, func(start, end int) bool {
+		found = m.accept(line, start, end)
+		return !found
+	})
+	return found
+}
+
+// find picks the leftmost-longest occurrences that don't overlap.
+func (m fixedMatcher) find(line string) [][]int {
+	var all [][]int
+	m.scan(line, func(start, end int) bool {
+		if m.accept(line, start, end) {
+			all = append(all, []int{start, end})
+		}
+		return true
+	})
+	sort.Slice(all, func(i, j int) bool {
+		if all[i][0] != all[j][0] {
+			return all[i][0] < all[j][0]
+		}
+		return all[i][1] > all[j][1]
+	})
+
+	var spans [][]int
+	pos := 0
+	for _, span := range all {
+		if span[0] < pos || span[0] == span[1] {
+			continue
 		}
+		spans = append(spans, span)
+		pos = span[1]
 	}
+	return spans
+}
 
-	for _, fileName := range fileNames {
-		err := search(fileName, check)
-		if err != nil {
-			fmt.Println(err)
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+// wordBoundary reports whether line[start:end] is not glued to word runes.
+func wordBoundary(line string, start, end int) bool {
+	if start > 0 {
+		if r, _ := utf8.DecodeLastRuneInString(line[:start]); isWordRune(r) {
+			return false
+		}
+	}
+	if end < len(line) {
+		if r, _ := utf8.DecodeRuneInString(line[end:]); isWordRune(r) {
+			return false
 		}
 	}
+	return true
 }
 
-func search(fileName string, check func(string) bool) error {
-	lines, err := readFile(fileName)
-	if err != nil {
-		return err
+func newMatcher(patterns []string) (matcher, error) {
+	if fixed && perl {
+		return nil, errorMatchers
 	}
 
-	builder := strings.Builder{}
-	var counter int
-	for i, line := range lines {
+	if perl {
+		if len(patterns) != 1 {
+			return nil, errorPerlPatterns
+		}
+		pattern := patterns[0]
+		expr := pattern
+		switch {
+		case lineRegexp:
+			expr = "^(?:" + expr + ")$"
+		case wordRegexp:
+			expr = `(?<!\w)(?:` + expr + `)(?!\w)`
+		}
 		if ignoreCase {
-			line = strings.ToLower(line)
+			expr = "(?i)" + expr
+		}
+
+		m, err := newPerlMatcher(expr, stepLimit)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w: %s", pattern, errorInvalidRegexp, err.Error())
 		}
+		return m, nil
+	}
 
-		if !check(line) {
+	if fixed {
+		m := fixedMatcher{fold: ignoreCase, word: wordRegexp, line: lineRegexp}
+		switch len(patterns) {
+		case 0:
+			// no patterns match nothing
+			m.ac = newAhoCorasick(nil, ignoreCase)
+		case 1:
+			m.pattern = patterns[0]
+		default:
+			m.ac = newAhoCorasick(patterns, ignoreCase)
+		}
+		return m, nil
+	}
+
+	exprs := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("%s: %w: %s", pattern, errorInvalidRegexp, err.Error())
+		}
+		exprs[i] = "(?:" + pattern + ")"
+	}
+	expr := strings.Join(exprs, "|")
+	if len(patterns) == 0 {
+		// an empty class never matches
+		expr = `[^\x00-\x{10FFFF}]`
+	}
+
+	flags := ""
+	if ignoreCase {
+		flags = "(?i)"
+	}
+
+	switch {
+	case lineRegexp:
+		return regexpMatcher{re: regexp.MustCompile(flags + "^(?:" + expr + ")$")}, nil
+	case wordRegexp:
+		return regexpMatcher{
+			re:   regexp.MustCompile(flags + "(?:^|" + wordClass + ")(" + expr + ")(?:" + wordClass + "|$)"),
+			mid:  regexp.MustCompile(flags + wordClass + "(" + expr + ")(?:" + wordClass + "|$)"),
+			word: true,
+		}, nil
+	}
+	return regexpMatcher{re: regexp.MustCompile(flags + expr)}, nil
+}
+
+// indexFold is strings.Index under Unicode simple case folding, like
+// strings.EqualFold. The match may differ from substr in byte length, so
+// its end is returned too.
+func indexFold(s, substr string) (int, int) {
+	for i := 0; i <= len(s); {
+		if n, ok := hasPrefixFold(s[i:], substr); ok {
+			return i, i + n
+		}
+		if i == len(s) {
+			break
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	return -1, -1
+}
+
+// hasPrefixFold returns the length of the prefix of s that matches prefix.
+func hasPrefixFold(s, prefix string) (int, bool) {
+	n := 0
+	for prefix != "" {
+		if s == "" {
+			return 0, false
+		}
+		r1, size1 := utf8.DecodeRuneInString(s)
+		r2, size2 := utf8.DecodeRuneInString(prefix)
+		if r1 != r2 && !equalFoldRune(r1, r2) {
+			return 0, false
+		}
+		s, prefix = s[size1:], prefix[size2:]
+		n += size1
+	}
+	return n, true
+}
+
+func equalFoldRune(r1, r2 rune) bool {
+	for r := unicode.SimpleFold(r1); r != r1; r = unicode.SimpleFold(r) {
+		if r == r2 {
+			return true
+		}
+	}
+	return false
+}
+
+// listFiles calls fn with every file to search in order, errors of
+// arguments and directories are passed in their place.
+func listFiles(fileNames []string, fn func(path string, err error)) {
+	onFile := func(path string) { fn(path, nil) }
+	onError := func(err error) { fn("", err) }
+
+	for _, fileName := range fileNames {
+		info, err := os.Stat(fileName)
+		if err != nil || !info.IsDir() {
+			onFile(fileName)
 			continue
 		}
 
-		if count {
-			counter++
+		if !recursive {
+			onError(fmt.Errorf("%s: %w", fileName, errorIsDirectory))
+			continue
+		}
+		walk(fileName, onFile, onError)
+	}
+}
+
+type fileJob struct {
+	path  string
+	stats fileStats
+	err   error
+	out   bytes.Buffer
+	done  chan struct{}
+}
+
+// searchParallel searches files with n workers. Output of every file is
+// buffered and printed in the order of listFiles, at most 2*n files are in
+// flight so a slow file only holds back a bounded amount of output.
+// When -q has its answer the rest of the files is skipped, the goroutines
+// are stopped before it returns.
+func searchParallel(w io.Writer, fileNames []string, n int, m matcher) summary {
+	jobsCh := make(chan *fileJob)
+	ordered := make(chan *fileJob, 2*n)
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer close(stop)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobsCh)
+		defer close(ordered)
+
+		listFiles(fileNames, func(path string, err error) {
+			job := &fileJob{path: path, err: err, done: make(chan struct{})}
+			select {
+			case ordered <- job:
+			case <-stop:
+				return
+			}
+			if err != nil {
+				close(job.done)
+				return
+			}
+			select {
+			case jobsCh <- job:
+			case <-stop:
+			}
+		})
+	}()
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobsCh {
+				select {
+				case <-stop:
+				default:
+					job.stats, job.err = search(&job.out, job.path, m)
+				}
+				close(job.done)
+			}
+		}()
+	}
+
+	var sum summary
+	for job := range ordered {
+		<-job.done
+		job.out.WriteTo(w)
+		if job.err != nil {
+			if flusher, ok := w.(interface{ Flush() error }); ok {
+				flusher.Flush()
+			}
+			fmt.Fprintln(os.Stderr, job.err)
+		}
+
+		sum.add(job.stats, job.err)
+		// the rest of the files doesn't change the result
+		if quiet && sum.selected {
+			break
+		}
+	}
+
+	return sum
+}
+
+// paint wraps s into color codes when colors are on.
+func paint(w io.Writer, code, s string) {
+	if color {
+		io.WriteString(w, code+s+colorReset)
+		return
+	}
+	io.WriteString(w, s)
+}
+
+// writePrefix prints GNU style prefixes: "N:" for selected lines, "N-" for context ones.
+func writePrefix(w io.Writer, name string, num int, offset int64, sep byte) {
+	if withFileName {
+		paint(w, colorFile, name)
+		paint(w, colorSep, string(sep))
+	}
+	if lineNum {
+		paint(w, colorLineNum, strconv.Itoa(num))
+		paint(w, colorSep, string(sep))
+	}
+	if byteOffset {
+		paint(w, colorLineNum, strconv.FormatInt(offset, 10))
+		paint(w, colorSep, string(sep))
+	}
+}
+
+// writeLine prints a line with its prefix, spans of matches are highlighted.
+func writeLine(w io.Writer, name string, num int, offset int64, sep byte, line string, spans [][]int) {
+	writePrefix(w, name, num, offset, sep)
+
+	prev := 0
+	for _, span := range spans {
+		if span[0] == span[1] {
 			continue
 		}
+		io.WriteString(w, line[prev:span[0]])
+		paint(w, colorMatch, line[span[0]:span[1]])
+		prev = span[1]
+	}
+	io.WriteString(w, line[prev:])
+	io.WriteString(w, "\n")
+}
+
+type ringLine struct {
+	line   string
+	offset int64
+}
+
+// ring keeps the last lines that weren't printed yet for -B.
+type ring struct {
+	lines []ringLine
+	start int
+	size  int
+}
+
+func newRing(capacity int) *ring {
+	return &ring{lines: make([]ringLine, capacity)}
+}
+
+func (r *ring) push(line ringLine) {
+	if len(r.lines) == 0 {
+		return
+	}
+	if r.size < len(r.lines) {
+		r.lines[(r.start+r.size)%len(r.lines)] = line
+		r.size++
+		return
+	}
+	r.lines[r.start] = line
+	r.start = (r.start + 1) % len(r.lines)
+}
+
+func (r *ring) get(i int) ringLine {
+	return r.lines[(r.start+i)%len(r.lines)]
+}
+
+func (r *ring) reset() {
+	r.start, r.size = 0, 0
+}
+
+func search(w io.Writer, fileName string, m matcher) (fileStats, error) {
+	input, err := os.Open(fileName)
+	if err != nil {
+		return fileStats{}, fmt.Errorf("%w: %s", errorFileNotFound, fileName)
+	}
+	defer input.Close()
+
+	return grepInput(w, fileName, input, m)
+}
+
+// grepInput decompresses r with -z and greps it.
+func grepInput(w io.Writer, name string, r io.Reader, m matcher) (fileStats, error) {
+	if decompress {
+		dr, err := newDecompressor(r)
+		if err != nil {
+			return fileStats{}, fmt.Errorf("%s: %w", name, err)
+		}
+		r = dr
+	}
+	return grep(w, name, r, m)
+}
+
+// newDecompressor detects gzip and bzip2 by magic bytes, other input is
+// returned as is.
+func newDecompressor(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(3)
 
-		from, to := i-before, i+after+1
-		if from < 0 {
-			from = 0
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		// concatenated gzip members are read as one stream
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return bzip2.NewReader(br), nil
+	}
+	return br, nil
+}
+
+// grep reads r line by line, so memory is bound by the longest line and -B.
+// Output is flushed whenever the input has nothing buffered, so matches from
+// a slow pipe show up at once.
+func grep(w io.Writer, name string, r io.Reader, m matcher) (stats fileStats, err error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	flusher, _ := w.(interface{ Flush() error })
+
+	// the -P matcher gives up on a line by panicking with errorStepLimit
+	// or errorDepthLimit
+	defer func() {
+		if e := recover(); e != nil {
+			if e != errorStepLimit && e != errorDepthLimit {
+				panic(e)
+			}
+			err = fmt.Errorf("%s: %w", name, e.(error))
 		}
-		if to > len(lines) {
-			to = len(lines)
+	}()
+
+	// a NUL in the first chunk or in any line marks the input as binary,
+	// its lines are not printed, a single message is printed instead
+	br.Peek(1)
+	buffered, _ := br.Peek(br.Buffered())
+	binaryOffset := int64(bytes.IndexByte(buffered, 0))
+	binary := binaryOffset >= 0
+
+	var jf *jsonFile
+	if jsonOutput {
+		jf = newJSONFile(w, name)
+		defer func() {
+			jf.end(stats, binaryOffset)
+		}()
+	}
+
+	// printLine prints a selected line with sep ':' or a context one with '-'
+	printLine := func(num int, offset int64, sep byte, line string, eol bool, spans [][]int) {
+		if jf == nil {
+			writeLine(w, name, num, offset, sep, line, spans)
+			return
+		}
+		kind := "match"
+		if sep == '-' {
+			kind = "context"
+		}
+		if eol {
+			line += "\n"
+		}
+		jf.line(kind, num, offset, line, spans)
+	}
+
+	printing := !count && !quiet && !filesWithMatches && !filesWithoutMatch
+	withContext := printing && !onlyMatching
+	useSeparator := withContext && (before > 0 || after > 0) && !noGroupSeparator && !jsonOutput
+
+	beforeLines := newRing(0)
+	if withContext {
+		beforeLines = newRing(before)
+	}
+
+	// last is the number of the last printed line, lines are numbered from 1
+	last, afterLeft := 0, 0
+	for num := 1; ; num++ {
+		if flusher != nil && br.Buffered() == 0 {
+			if err := flusher.Flush(); err != nil {
+				return stats, err
+			}
+		}
+
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return stats, fmt.Errorf("%s: %w", name, err)
+		}
+		if line == "" && err == io.EOF {
+			break
+		}
+		lineOffset := stats.bytes
+		stats.bytes += int64(len(line))
+		eol := strings.HasSuffix(line, "\n")
+		line = strings.TrimSuffix(line, "\n")
+		if !binary {
+			if i := strings.IndexByte(line, 0); i >= 0 {
+				binary, binaryOffset = true, lineOffset+int64(i)
+			}
 		}
 
-		for k := from; k < to; k++ {
-			if builder.Len() > 0 {
-				builder.WriteString("\n")
+		if maxCount > 0 && stats.selected >= maxCount {
+			// after -m NUM only the trailing context of the last match is left
+			if afterLeft == 0 || !withContext || binary {
+				break
 			}
-			if lineNum {
-				builder.WriteString(fmt.Sprintf("%d:", i))
+			printLine(num, lineOffset, '-', line, eol, nil)
+			afterLeft--
+			continue
+		}
+
+		if m.match(line) == invert {
+			if afterLeft > 0 && withContext && !binary {
+				printLine(num, lineOffset, '-', line, eol, nil)
+				last = num
+				afterLeft--
+			} else {
+				beforeLines.push(ringLine{line: line, offset: lineOffset})
 			}
-			builder.WriteString(lines[k])
+			continue
 		}
+		stats.selected++
+
+		switch {
+		case quiet:
+			return stats, nil
+
+		case filesWithMatches:
+			paint(w, colorFile, name)
+			io.WriteString(w, "\n")
+			return stats, nil
+
+		case filesWithoutMatch:
+			return stats, nil
+
+		case count:
+			continue
+
+		case binary:
+			if jf != nil {
+				jf.begin()
+				return stats, nil
+			}
+			fmt.Fprintf(w, "Binary file %s matches\n", name)
+			return stats, nil
+
+		case onlyMatching:
+			// with -v there is no match to print
+			if invert {
+				continue
+			}
+			for _, span := range m.find(line) {
+				if span[0] == span[1] {
+					continue
+				}
+				writePrefix(w, name, num, lineOffset+int64(span[0]), ':')
+				paint(w, colorMatch, line[span[0]:span[1]])
+				io.WriteString(w, "\n")
+			}
+			continue
+		}
+
+		from := num - beforeLines.size
+		if useSeparator && last > 0 && from > last+1 {
+			paint(w, colorSep, groupSeparator)
+			io.WriteString(w, "\n")
+		}
+
+		for i := 0; i < beforeLines.size; i++ {
+			ctx := beforeLines.get(i)
+			// lines kept for -B always had a line after them
+			printLine(from+i, ctx.offset, '-', ctx.line, true, nil)
+		}
+		beforeLines.reset()
+
+		var spans [][]int
+		if (color || jsonOutput) && !invert {
+			spans = m.find(line)
+			for _, span := range spans {
+				if span[0] != span[1] {
+					stats.matches++
+				}
+			}
+		}
+		printLine(num, lineOffset, ':', line, eol, spans)
+		last, afterLeft = num, after
 	}
 
 	if count {
-		fmt.Println(counter)
-		return nil
+		if withFileName {
+			paint(w, colorFile, name)
+			paint(w, colorSep, ":")
+		}
+		fmt.Fprintln(w, stats.selected)
+	}
+	if filesWithoutMatch && stats.selected == 0 {
+		paint(w, colorFile, name)
+		io.WriteString(w, "\n")
 	}
 
-	if builder.Len() > 0 {
-		fmt.Println(builder.String())
+	return stats, nil
+}
+
+//~~~~~~~~~~~~~~~~~~~
+
+// jsonText holds text or, for invalid UTF-8, base64 bytes like ripgrep does.
+type jsonText struct {
+	Text  *string `json:"text,omitempty"`
+	Bytes []byte  `json:"bytes,omitempty"`
+}
+
+func newJSONText(s string) jsonText {
+	if utf8.ValidString(s) {
+		return jsonText{Text: &s}
 	}
+	return jsonText{Bytes: []byte(s)}
+}
 
-	return nil
+type jsonEvent struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
 }
 
-func readFile(fileName string) ([]string, error) {
-	input, err := os.Open(fileName)
+type jsonBegin struct {
+	Path jsonText `json:"path"`
+}
+
+type jsonSubmatch struct {
+	Match jsonText `json:"match"`
+	Start int      `json:"start"`
+	End   int      `json:"end"`
+}
+
+type jsonLine struct {
+	Path           jsonText       `json:"path"`
+	Lines          jsonText       `json:"lines"`
+	LineNumber     int            `json:"line_number"`
+	AbsoluteOffset int64          `json:"absolute_offset"`
+	Submatches     []jsonSubmatch `json:"submatches"`
+}
+
+type jsonStats struct {
+	MatchedLines      int   `json:"matched_lines"`
+	Matches           int   `json:"matches"`
+	BytesSearched     int64 `json:"bytes_searched"`
+	Searches          int   `json:"searches,omitempty"`
+	SearchesWithMatch int   `json:"searches_with_match,omitempty"`
+}
+
+type jsonEnd struct {
+	Path         jsonText  `json:"path"`
+	BinaryOffset *int64    `json:"binary_offset"`
+	Stats        jsonStats `json:"stats"`
+}
+
+type jsonElapsed struct {
+	Secs  int64  `json:"secs"`
+	Nanos int64  `json:"nanos"`
+	Human string `json:"human"`
+}
+
+type jsonSummary struct {
+	ElapsedTotal jsonElapsed `json:"elapsed_total"`
+	Stats        jsonStats   `json:"stats"`
+}
+
+// jsonFile prints events of one file, like ripgrep it begins with the
+// first printed line, files without them print nothing.
+type jsonFile struct {
+	enc   *json.Encoder
+	path  string
+	begun bool
+}
+
+func newJSONFile(w io.Writer, path string) *jsonFile {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &jsonFile{enc: enc, path: path}
+}
+
+func (f *jsonFile) begin() {
+	if f.begun {
+		return
+	}
+	f.begun = true
+	f.enc.Encode(jsonEvent{Type: "begin", Data: jsonBegin{Path: newJSONText(f.path)}})
+}
+
+func (f *jsonFile) line(kind string, num int, offset int64, line string, spans [][]int) {
+	f.begin()
+
+	submatches := make([]jsonSubmatch, 0, len(spans))
+	for _, span := range spans {
+		if span[0] == span[1] {
+			continue
+		}
+		submatches = append(submatches, jsonSubmatch{
+			Match: newJSONText(line[span[0]:span[1]]),
+			Start: span[0],
+			End:   span[1],
+		})
+	}
+
+	f.enc.Encode(jsonEvent{Type: kind, Data: jsonLine{
+		Path:           newJSONText(f.path),
+		Lines:          newJSONText(line),
+		LineNumber:     num,
+		AbsoluteOffset: offset,
+		Submatches:     submatches,
+	}})
+}
+
+// end is printed only after begin, binaryOffset is -1 for text files.
+func (f *jsonFile) end(stats fileStats, binaryOffset int64) {
+	if !f.begun {
+		return
+	}
+
+	var offset *int64
+	if binaryOffset >= 0 {
+		offset = &binaryOffset
+	}
+	f.enc.Encode(jsonEvent{Type: "end", Data: jsonEnd{
+		Path:         newJSONText(f.path),
+		BinaryOffset: offset,
+		Stats: jsonStats{
+			MatchedLines:  stats.selected,
+			Matches:       stats.matches,
+			BytesSearched: stats.bytes,
+		},
+	}})
+}
+
+func writeJSONSummary(w io.Writer, sum summary, elapsed time.Duration) {
+	enc := json.NewEncoder(w)
+	enc.Encode(jsonEvent{Type: "summary", Data: jsonSummary{
+		ElapsedTotal: jsonElapsed{
+			Secs:  int64(elapsed / time.Second),
+			Nanos: int64(elapsed % time.Second),
+			Human: elapsed.String(),
+		},
+		Stats: jsonStats{
+			MatchedLines:      sum.total.selected,
+			Matches:           sum.total.matches,
+			BytesSearched:     sum.total.bytes,
+			Searches:          sum.searches,
+			SearchesWithMatch: sum.searchesWithMatch,
+		},
+	}})
+}
+
+//~~~~~~~~~~~~~~~~~~~
+
+// ahoCorasick finds many fixed patterns in one pass over a line. It works
+// on runes, with -i every rune is replaced by the smallest of its fold orbit.
+type ahoCorasick struct {
+	// edges maps state<<32 | rune to the next state, state 0 is the root
+	edges map[uint64]int32
+	fail  []int32
+	// out keeps rune lengths of patterns ending in the state, suffixes included
+	out  [][]int32
+	fold bool
+}
+
+func newAhoCorasick(patterns []string, fold bool) *ahoCorasick {
+	ac := &ahoCorasick{
+		edges: make(map[uint64]int32),
+		fail:  []int32{0},
+		out:   [][]int32{nil},
+		fold:  fold,
+	}
+
+	// children keeps the trie for the breadth-first pass
+	children := [][]int32{nil}
+	runes := [][]rune{nil}
+	for _, pattern := range patterns {
+		state := int32(0)
+		length := int32(0)
+		for _, r := range pattern {
+			r = ac.canon(r)
+			next, ok := ac.edges[edgeKey(state, r)]
+			if !ok {
+				next = int32(len(ac.fail))
+				ac.edges[edgeKey(state, r)] = next
+				ac.fail = append(ac.fail, 0)
+				ac.out = append(ac.out, nil)
+				children = append(children, nil)
+				runes = append(runes, nil)
+				children[state] = append(children[state], next)
+				runes[state] = append(runes[state], r)
+			}
+			state = next
+			length++
+		}
+		ac.out[state] = append(ac.out[state], length)
+	}
+
+	queue := []int32{0}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+
+		for i, child := range children[state] {
+			queue = append(queue, child)
+			if state == 0 {
+				ac.out[child] = append(ac.out[child], ac.out[0]...)
+				continue
+			}
+
+			r := runes[state][i]
+			f := ac.fail[state]
+			for {
+				if next, ok := ac.edges[edgeKey(f, r)]; ok {
+					ac.fail[child] = next
+					break
+				}
+				if f == 0 {
+					break
+				}
+				f = ac.fail[f]
+			}
+			ac.out[child] = append(ac.out[child], ac.out[ac.fail[child]]...)
+		}
+	}
+
+	return ac
+}
+
+func edgeKey(state int32, r rune) uint64 {
+	return uint64(state)<<32 | uint64(uint32(r))
+}
+
+func (ac *ahoCorasick) canon(r rune) rune {
+	if !ac.fold {
+		return r
+	}
+	min := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < min {
+			min = f
+		}
+	}
+	return min
+}
+
+// scan calls fn with byte offsets of every occurrence until fn returns false.
+func (ac *ahoCorasick) scan(line string, fn func(start, end int) bool) {
+	for range ac.out[0] {
+		if !fn(0, 0) {
+			return
+		}
+	}
+
+	// starts keeps the byte offset of every rune read so far
+	starts := make([]int, 0, len(line))
+	state := int32(0)
+	for i, r := range line {
+		starts = append(starts, i)
+		r = ac.canon(r)
+		for {
+			if next, ok := ac.edges[edgeKey(state, r)]; ok {
+				state = next
+				break
+			}
+			if state == 0 {
+				break
+			}
+			state = ac.fail[state]
+		}
+
+		_, size := utf8.DecodeRuneInString(line[i:])
+		end := i + size
+		for _, length := range ac.out[state] {
+			start := end
+			if length > 0 {
+				start = starts[len(starts)-int(length)]
+			}
+			if !fn(start, end) {
+				return
+			}
+		}
+	}
+}
+
+//~~~~~~~~~~~~~~~~~~~
+
+// walk calls fn for every file under dir that passes the globs and ignore
+// rules, errors of reading directories go to onError. Symlinks met inside
+// are followed with -R only.
+func walk(dir string, fn func(path string), onError func(error)) {
+	walkDir(dir, true, nil, nil, fn, onError)
+}
+
+func walkDir(dir string, root bool, ignores []*ignoreList, parents []os.FileInfo, fn func(path string), onError func(error)) {
+	if !root && excludeDirs.match(filepath.Base(dir)) {
+		return
+	}
+
+	info, err := os.Stat(dir)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %s", errorFileNotFound, fileName)
+		onError(fmt.Errorf("%w: %s", errorFileNotFound, dir))
+		return
+	}
+	// -R may lead into a loop of symlinks
+	for _, parent := range parents {
+		if os.SameFile(parent, info) {
+			return
+		}
 	}
-	b, err := ioutil.ReadAll(input)
+	parents = append(parents, info)
+
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return nil, fmt.Errorf("Error with file %s in search - readFile - ioutil.ReadAll(): %w", fileName, err)
+		onError(err)
+		return
+	}
+
+	if gitignore {
+		list, err := readIgnoreFile(dir)
+		if err != nil {
+			onError(err)
+		} else if list != nil {
+			ignores = append(ignores[:len(ignores):len(ignores)], list)
+		}
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+
+		isDir := entry.IsDir()
+		if entry.Type()&os.ModeSymlink != 0 {
+			if !dereference {
+				continue
+			}
+			target, err := os.Stat(path)
+			if err != nil {
+				onError(fmt.Errorf("%w: %s", errorFileNotFound, path))
+				continue
+			}
+			isDir = target.IsDir()
+		}
+
+		if gitignore && (isDir && entry.Name() == ".git" || ignored(ignores, path, isDir)) {
+			continue
+		}
+
+		if isDir {
+			walkDir(path, false, ignores, parents, fn, onError)
+			continue
+		}
+		if !entry.Type().IsRegular() && entry.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		if len(includes) > 0 && !includes.match(entry.Name()) || excludes.match(entry.Name()) {
+			continue
+		}
+		fn(path)
+	}
+}
+
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreList holds the rules of a .gitignore file, they apply to paths
+// relative to base.
+type ignoreList struct {
+	base  string
+	rules []ignoreRule
+}
+
+func readIgnoreFile(dir string) (*ignoreList, error) {
+	input, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	list, err := parseIgnore(input)
+	if err != nil {
+		return nil, fmt.Errorf("Error in readIgnoreFile with %s: %w", dir, err)
+	}
+	list.base = filepath.Clean(dir)
+	return list, nil
+}
+
+func parseIgnore(r io.Reader) (*ignoreList, error) {
+	list := &ignoreList{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		pattern := strings.TrimRight(scanner.Text(), " ")
+		if pattern == "" || pattern[0] == '#' {
+			continue
+		}
+
+		var rule ignoreRule
+		if pattern[0] == '!' {
+			rule.negate = true
+			pattern = pattern[1:]
+		} else if strings.HasPrefix(pattern, "\\") {
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			rule.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+		if pattern == "" {
+			continue
+		}
+
+		// a slash anywhere but the end anchors the pattern to the .gitignore directory
+		anchored := strings.Contains(pattern, "/")
+		pattern = strings.TrimPrefix(pattern, "/")
+
+		expr := globToRegexp(pattern)
+		if !anchored {
+			expr = "(.*/)?" + expr
+		}
+		re, err := regexp.Compile("^" + expr + "$")
+		if err != nil {
+			continue
+		}
+		rule.re = re
+		list.rules = append(list.rules, rule)
+	}
+
+	return list, scanner.Err()
+}
+
+// globToRegexp translates gitignore glob syntax, "**" matches across directories.
+func globToRegexp(pattern string) string {
+	builder := strings.Builder{}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			switch {
+			case strings.HasPrefix(pattern[i:], "**/"):
+				builder.WriteString("(.*/)?")
+				i += 2
+			case strings.HasPrefix(pattern[i:], "**"):
+				builder.WriteString(".*")
+				i++
+			default:
+				builder.WriteString("[^/]*")
+			}
+		case '?':
+			builder.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				builder.WriteString("\\[")
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			builder.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			fallthrough
+		default:
+			// whole runes, a byte of a multibyte rune is not a rune
+			_, size := utf8.DecodeRuneInString(pattern[i:])
+			builder.WriteString(regexp.QuoteMeta(pattern[i : i+size]))
+			i += size - 1
+		}
+	}
+	return builder.String()
+}
+
+// match returns whether the rules decide on the path and whether it's ignored,
+// the last matching rule wins.
+func (l *ignoreList) match(path string, isDir bool) (bool, bool) {
+	rel, err := filepath.Rel(l.base, filepath.Clean(path))
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false, false
+	}
+	rel = filepath.ToSlash(rel)
+
+	decided, ignore := false, false
+	for _, rule := range l.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(rel) {
+			decided, ignore = true, !rule.negate
+		}
+	}
+	return decided, ignore
+}
+
+// ignored checks the path against .gitignore files from the deepest one up.
+func ignored(ignores []*ignoreList, path string, isDir bool) bool {
+	for i := len(ignores) - 1; i >= 0; i-- {
+		if decided, ignore := ignores[i].match(path, isDir); decided {
+			return ignore
+		}
 	}
-	return strings.Split(string(b), "\n"), nil
+	return false
 }
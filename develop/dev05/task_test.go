@@ -1 +1,795 @@
 package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testInput = "a1\nb\nc\nd\na2\ne\nf\ng\nh\na3\na4\n"
+
+func writeTestFile(t *testing.T, content string) string {
+	t.Helper()
+	fileName := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func resetFlags() {
+	after, before, context = 0, 0, 0
+	count, ignoreCase, smartCase, invert, fixed, lineNum = false, false, false, false, false, false
+	perl, stepLimit = false, 1000000
+	groupSeparator, noGroupSeparator = "--", false
+	onlyMatching, color, byteOffset, quiet, jsonOutput, decompress = false, false, false, false, false, false
+	wordRegexp, lineRegexp = false, false
+	filesWithMatches, filesWithoutMatch, maxCount = false, false, 0
+	recursive, dereference, gitignore, withFileName = false, false, false, false
+	includes, excludes, excludeDirs = nil, nil, nil
+}
+
+var containsA matcher = fixedMatcher{pattern: "a"}
+
+func TestSearchContext(t *testing.T) {
+	fileName := writeTestFile(t, testInput)
+
+	testTable := []struct {
+		name   string
+		setup  func()
+		result string
+	}{
+		{
+			name:   "no context",
+			setup:  func() { lineNum = true },
+			result: "1:a1\n5:a2\n10:a3\n11:a4\n",
+		},
+		{
+			name:   "overlapping context is merged",
+			setup:  func() { lineNum, before, after = true, 1, 1 },
+			result: "1:a1\n2-b\n--\n4-d\n5:a2\n6-e\n--\n9-h\n10:a3\n11:a4\n",
+		},
+		{
+			name:   "adjacent groups have no separator",
+			setup:  func() { before, after = 2, 2 },
+			result: "a1\nb\nc\nd\na2\ne\nf\ng\nh\na3\na4\n",
+		},
+		{
+			name:   "custom separator",
+			setup:  func() { after, groupSeparator = 1, "##" },
+			result: "a1\nb\n##\na2\ne\n##\na3\na4\n",
+		},
+		{
+			name:   "no separator",
+			setup:  func() { before, noGroupSeparator = 1, true },
+			result: "a1\nd\na2\nh\na3\na4\n",
+		},
+		{
+			name:   "count",
+			setup:  func() { count, after = true, 3 },
+			result: "4\n",
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			resetFlags()
+			testCase.setup()
+
+			builder := strings.Builder{}
+			if _, err := search(&builder, fileName, containsA); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if builder.String() != testCase.result {
+				t.Errorf("Incorrect result: expect %q, got %q", testCase.result, builder.String())
+			}
+		})
+	}
+}
+
+func TestSearchFileNotFound(t *testing.T) {
+	resetFlags()
+	builder := strings.Builder{}
+	if _, err := search(&builder, filepath.Join(t.TempDir(), "none"), containsA); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestGrepLongLines(t *testing.T) {
+	resetFlags()
+	long := strings.Repeat("x", 1<<20) + "a"
+	input := "b\n" + long + "\nc"
+
+	builder := strings.Builder{}
+	if _, err := grep(&builder, "input", strings.NewReader(input), containsA); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if builder.String() != long+"\n" {
+		t.Errorf("Incorrect result of %d bytes", builder.Len())
+	}
+}
+
+func TestGrepNoTrailingNewline(t *testing.T) {
+	resetFlags()
+	lineNum, before = true, 5
+
+	builder := strings.Builder{}
+	if _, err := grep(&builder, "input", strings.NewReader("b\nc\na"), containsA); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expect := "1-b\n2-c\n3:a\n"; builder.String() != expect {
+		t.Errorf("Incorrect result: expect %q, got %q", expect, builder.String())
+	}
+}
+
+func TestGrepFlushesPipe(t *testing.T) {
+	resetFlags()
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	out := bufio.NewWriter(outW)
+
+	done := make(chan error)
+	go func() {
+		_, err := grep(out, "input", inR, containsA)
+		done <- err
+		outW.Close()
+	}()
+
+	// the match has to come out while the input is still open
+	go inW.Write([]byte("b\na1\n"))
+	line, err := bufio.NewReader(outR).ReadString('\n')
+	if err != nil || line != "a1\n" {
+		t.Errorf("Incorrect result: expect %q, got %q, %v", "a1\n", line, err)
+	}
+
+	inW.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestRing(t *testing.T) {
+	r := newRing(2)
+	for i, line := range []string{"a", "b", "c"} {
+		r.push(ringLine{line: line, offset: int64(i)})
+	}
+	if r.size != 2 || r.get(0).line != "b" || r.get(1) != (ringLine{line: "c", offset: 2}) {
+		t.Errorf("Incorrect ring: %v start %d size %d", r.lines, r.start, r.size)
+	}
+
+	r.reset()
+	r.push(ringLine{line: "d"})
+	if r.size != 1 || r.get(0).line != "d" {
+		t.Errorf("Incorrect ring after reset: %v start %d size %d", r.lines, r.start, r.size)
+	}
+
+	empty := newRing(0)
+	empty.push(ringLine{line: "a"})
+	if empty.size != 0 {
+		t.Errorf("Zero capacity ring must stay empty, size %d", empty.size)
+	}
+}
+
+func TestGrepBinary(t *testing.T) {
+	resetFlags()
+
+	testTable := []struct {
+		input  string
+		result string
+	}{
+		{input: "b\x00\na\nb\n", result: "Binary file input matches\n"},
+		{input: "a\nb\x00\na\n", result: "Binary file input matches\n"},
+		// NUL after the first chunk, the text before it is already printed
+		{input: "a\n" + strings.Repeat("x\n", 64*1024) + "b\x00\na\n", result: "a\nBinary file input matches\n"},
+		{input: "b\x00\nc\n", result: ""},
+	}
+
+	for _, testCase := range testTable {
+		builder := strings.Builder{}
+		if _, err := grep(&builder, "input", strings.NewReader(testCase.input), containsA); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if builder.String() != testCase.result {
+			t.Errorf("Incorrect result for %q: expect %q, got %q", testCase.input, testCase.result, builder.String())
+		}
+	}
+}
+
+func TestIgnoreRules(t *testing.T) {
+	list, err := parseIgnore(strings.NewReader("# comment\nbuild/\n*.log\n!keep.log\n/root.txt\ndocs/**/*.md\nфайл.txt\nдок?.md\n\\ё*.go\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	list.base = "."
+
+	testTable := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{path: "build", isDir: true, ignored: true},
+		{path: "src/build", isDir: true, ignored: true},
+		{path: "build", isDir: false, ignored: false},
+		{path: "a.log", ignored: true},
+		{path: "src/a.log", ignored: true},
+		{path: "src/keep.log", ignored: false},
+		{path: "root.txt", ignored: true},
+		{path: "src/root.txt", ignored: false},
+		{path: "docs/a.md", ignored: true},
+		{path: "docs/x/y/a.md", ignored: true},
+		{path: "a.md", ignored: false},
+		{path: "a/файл.txt", ignored: true},
+		{path: "фаил.txt", ignored: false},
+		{path: "доки.md", ignored: true},
+		{path: "док.md", ignored: false},
+		{path: "ёж.go", ignored: true},
+	}
+
+	for _, testCase := range testTable {
+		if result := ignored([]*ignoreList{list}, testCase.path, testCase.isDir); result != testCase.ignored {
+			t.Errorf("Incorrect result for %s: expect %v, got %v", testCase.path, testCase.ignored, result)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	resetFlags()
+
+	root := t.TempDir()
+	for _, name := range []string{"a.go", "b.txt", "vendor/c.go", "sub/d.go", "sub/e.log", ".git/f.go"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, ".gitignore"), []byte("*.log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	includes = globList{"*.go", "*.log"}
+	excludeDirs = globList{"vendor"}
+	gitignore = true
+
+	var found []string
+	walk(root, func(path string) {
+		rel, _ := filepath.Rel(root, path)
+		found = append(found, filepath.ToSlash(rel))
+	}, func(err error) {
+		t.Errorf("Unexpected error: %v", err)
+	})
+
+	expect := []string{"a.go", "sub/d.go"}
+	if !reflect.DeepEqual(found, expect) {
+		t.Errorf("Incorrect files: expect %v, got %v", expect, found)
+	}
+}
+
+func TestSearchParallelOrder(t *testing.T) {
+	resetFlags()
+	withFileName = true
+
+	root := t.TempDir()
+	var fileNames []string
+	expect := strings.Builder{}
+	for i := 0; i < 50; i++ {
+		fileName := filepath.Join(root, fmt.Sprintf("f%02d.txt", i))
+		content := strings.Repeat("b\n", i*100) + fmt.Sprintf("a%d\n", i)
+		if err := os.WriteFile(fileName, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fileNames = append(fileNames, fileName)
+		fmt.Fprintf(&expect, "%s:a%d\n", fileName, i)
+	}
+	missing := filepath.Join(root, "missing.txt")
+	fileNames = append(fileNames, missing)
+
+	builder := strings.Builder{}
+	sum := searchParallel(&builder, fileNames, 4, containsA)
+	if !sum.selected || !sum.failed || sum.status() != 2 {
+		t.Errorf("Incorrect summary: %+v", sum)
+	}
+
+	if builder.String() != expect.String() {
+		t.Errorf("Incorrect result: expect %q, got %q", expect.String(), builder.String())
+	}
+}
+
+// countingMatcher counts lines it was asked to match.
+type countingMatcher struct {
+	matcher
+	lines *atomic.Int64
+}
+
+func (m countingMatcher) match(line string) bool {
+	m.lines.Add(1)
+	return m.matcher.match(line)
+}
+
+func TestSearchParallelQuietStops(t *testing.T) {
+	resetFlags()
+	quiet = true
+	defer resetFlags()
+
+	root := t.TempDir()
+	var fileNames []string
+	for i := 0; i < 200; i++ {
+		fileName := filepath.Join(root, fmt.Sprintf("f%03d.txt", i))
+		if err := os.WriteFile(fileName, []byte("a\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fileNames = append(fileNames, fileName)
+	}
+
+	base := runtime.NumGoroutine()
+	m := countingMatcher{matcher: containsA, lines: &atomic.Int64{}}
+	sum := searchParallel(io.Discard, fileNames, 2, m)
+	if !sum.selected {
+		t.Errorf("Incorrect summary: %+v", sum)
+	}
+	if searched := m.lines.Load(); searched > 20 {
+		t.Errorf("Expected -q to stop early, %d of %d files searched", searched, len(fileNames))
+	}
+
+	for i := 0; i < 100 && runtime.NumGoroutine() > base; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > base {
+		t.Errorf("Incorrect goroutines: expect %d, got %d", base, n)
+	}
+}
+
+func TestNewMatcherIgnoreCase(t *testing.T) {
+	testTable := []struct {
+		name    string
+		pattern string
+		fixed   bool
+		smart   bool
+		line    string
+		result  bool
+	}{
+		{name: "regexp", pattern: "hello", line: "HeLLo world", result: true},
+		{name: "regexp class \\D", pattern: `^\D+$`, line: "ABC", result: true},
+		{name: "regexp class \\S", pattern: `\S\s\S`, line: "A B", result: true},
+		{name: "regexp class \\W", pattern: `^\W+$`, line: "ABC", result: false},
+		{name: "fixed", pattern: "привет", fixed: true, line: "ПРИВЕТ мир", result: true},
+		{name: "fixed kelvin", pattern: "k", fixed: true, line: "K", result: true},
+		{name: "fixed no match", pattern: "bye", fixed: true, line: "BYTE", result: false},
+		{name: "fixed metacharacters", pattern: "A.B", fixed: true, line: "xa.bx", result: true},
+		{name: "smart lower", pattern: "hello", smart: true, line: "HELLO", result: true},
+		{name: "smart upper", pattern: "Hello", smart: true, line: "HELLO", result: false},
+		{name: "smart class \\S", pattern: `fo\S`, smart: true, line: "Foo bar", result: true},
+		{name: "smart class \\P", pattern: `\P{Greek}\Bo`, smart: true, line: "FOO", result: true},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			resetFlags()
+			fixed, ignoreCase = testCase.fixed, !testCase.smart
+			if testCase.smart {
+				ignoreCase = !patternHasUpper(testCase.pattern)
+			}
+
+			m, err := newMatcher([]string{testCase.pattern})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result := m.match(testCase.line); result != testCase.result {
+				t.Errorf("Incorrect result for %q: expect %v, got %v", testCase.line, testCase.result, result)
+			}
+		})
+	}
+}
+
+func TestPatternHasUpper(t *testing.T) {
+	testTable := []struct {
+		pattern string
+		result  bool
+	}{
+		{pattern: `fo\S`, result: false},
+		{pattern: `\D\W\B\S+`, result: false},
+		{pattern: `\pL\PL\p{Lu}\P{Greek}`, result: false},
+		{pattern: `\xFF\x{1F600}`, result: false},
+		{pattern: `\d+Foo`, result: true},
+		{pattern: `\QAB\E`, result: true},
+		{pattern: `\Qab\Ec`, result: false},
+		{pattern: `\\S`, result: true},
+		{pattern: `Привет`, result: true},
+		{pattern: `\`, result: false},
+	}
+
+	for _, testCase := range testTable {
+		if result := patternHasUpper(testCase.pattern); result != testCase.result {
+			t.Errorf("Incorrect result for %q: expect %v, got %v", testCase.pattern, testCase.result, result)
+		}
+	}
+}
+
+func TestGrepIgnoreCaseKeepsText(t *testing.T) {
+	resetFlags()
+	ignoreCase = true
+
+	m, err := newMatcher([]string{`\w+ world`})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	builder := strings.Builder{}
+	if _, err := grep(&builder, "input", strings.NewReader("Hello WORLD\nbye\n"), m); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expect := "Hello WORLD\n"; builder.String() != expect {
+		t.Errorf("Incorrect result: expect %q, got %q", expect, builder.String())
+	}
+}
+
+func TestGrepOutputModes(t *testing.T) {
+	const input = "xa ya\nb\nza\nc\nd\na\n"
+
+	testTable := []struct {
+		name     string
+		setup    func()
+		result   string
+		selected int
+	}{
+		{
+			name:     "only matching",
+			setup:    func() { onlyMatching, lineNum = true, true },
+			result:   "1:a\n1:a\n3:a\n6:a\n",
+			selected: 3,
+		},
+		{
+			name:     "only matching with byte offsets",
+			setup:    func() { onlyMatching, byteOffset = true, true },
+			result:   "1:a\n4:a\n9:a\n15:a\n",
+			selected: 3,
+		},
+		{
+			name:     "byte offsets of lines",
+			setup:    func() { byteOffset, after = true, 1 },
+			result:   "0:xa ya\n6-b\n8:za\n11-c\n--\n15:a\n",
+			selected: 3,
+		},
+		{
+			name:     "color",
+			setup:    func() { color, lineNum, maxCount = true, true, 1 },
+			result:   "\x1b[32m\x1b[K1\x1b[m\x1b[K\x1b[36m\x1b[K:\x1b[m\x1b[Kx\x1b[01;31m\x1b[Ka\x1b[m\x1b[K y\x1b[01;31m\x1b[Ka\x1b[m\x1b[K\n",
+			selected: 1,
+		},
+		{
+			name:     "max count keeps trailing context",
+			setup:    func() { maxCount, after = 2, 2 },
+			result:   "xa ya\nb\nza\nc\nd\n",
+			selected: 2,
+		},
+		{
+			name:     "max count with count",
+			setup:    func() { maxCount, count = 3, true },
+			result:   "3\n",
+			selected: 3,
+		},
+		{
+			name:     "files with matches",
+			setup:    func() { filesWithMatches = true },
+			result:   "input\n",
+			selected: 1,
+		},
+		{
+			name:     "files without match",
+			setup:    func() { filesWithoutMatch = true },
+			result:   "",
+			selected: 1,
+		},
+		{
+			name:     "quiet",
+			setup:    func() { quiet = true },
+			result:   "",
+			selected: 1,
+		},
+		{
+			name:     "only matching inverted prints nothing",
+			setup:    func() { onlyMatching, invert = true, true },
+			result:   "",
+			selected: 3,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			resetFlags()
+			testCase.setup()
+
+			builder := strings.Builder{}
+			stats, err := grep(&builder, "input", strings.NewReader(input), containsA)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if builder.String() != testCase.result || stats.selected != testCase.selected {
+				t.Errorf("Incorrect result: expect %q %d, got %q %d",
+					testCase.result, testCase.selected, builder.String(), stats.selected)
+			}
+		})
+	}
+}
+
+func TestGrepFilesWithoutMatch(t *testing.T) {
+	resetFlags()
+	filesWithoutMatch = true
+
+	builder := strings.Builder{}
+	if _, err := grep(&builder, "input", strings.NewReader("b\nc\n"), containsA); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expect := "input\n"; builder.String() != expect {
+		t.Errorf("Incorrect result: expect %q, got %q", expect, builder.String())
+	}
+}
+
+func TestFixedMatcherFind(t *testing.T) {
+	testTable := []struct {
+		matcher fixedMatcher
+		line    string
+		result  [][]int
+	}{
+		{matcher: fixedMatcher{pattern: "ab"}, line: "abxab", result: [][]int{{0, 2}, {3, 5}}},
+		{matcher: fixedMatcher{pattern: "aa"}, line: "aaa", result: [][]int{{0, 2}}},
+		{matcher: fixedMatcher{pattern: "k", fold: true}, line: "x\u212ak", result: [][]int{{1, 4}, {4, 5}}},
+		{matcher: fixedMatcher{pattern: "ab"}, line: "ba", result: nil},
+	}
+
+	for _, testCase := range testTable {
+		if result := testCase.matcher.find(testCase.line); !reflect.DeepEqual(result, testCase.result) {
+			t.Errorf("Incorrect spans for %q in %q: expect %v, got %v",
+				testCase.matcher.pattern, testCase.line, testCase.result, result)
+		}
+	}
+}
+
+func TestSummaryStatus(t *testing.T) {
+	testTable := []struct {
+		sum    summary
+		quiet  bool
+		status int
+	}{
+		{sum: summary{selected: true}, status: 0},
+		{sum: summary{}, status: 1},
+		{sum: summary{failed: true}, status: 2},
+		{sum: summary{selected: true, failed: true}, status: 2},
+		{sum: summary{selected: true, failed: true}, quiet: true, status: 0},
+	}
+
+	for _, testCase := range testTable {
+		resetFlags()
+		quiet = testCase.quiet
+		if status := testCase.sum.status(); status != testCase.status {
+			t.Errorf("Incorrect status for %+v: expect %d, got %d", testCase.sum, testCase.status, status)
+		}
+	}
+}
+
+func TestNewMatcherPatterns(t *testing.T) {
+	testTable := []struct {
+		name     string
+		patterns []string
+		setup    func()
+		line     string
+		result   [][]int
+	}{
+		{name: "regexp list", patterns: []string{"a+", "b"}, line: "xaab b", result: [][]int{{1, 3}, {3, 4}, {5, 6}}},
+		{name: "fixed list", patterns: []string{"ab", "b", "abc"}, setup: func() { fixed = true }, line: "abcb", result: [][]int{{0, 3}, {3, 4}}},
+		{name: "fixed list ignore case", patterns: []string{"ПРИ", "вет"}, setup: func() { fixed, ignoreCase = true, true }, line: "привет", result: [][]int{{0, 6}, {6, 12}}},
+		{name: "no patterns", patterns: nil, line: "abc", result: nil},
+		{name: "fixed no patterns", patterns: nil, setup: func() { fixed = true }, line: "abc", result: nil},
+		{name: "regexp words", patterns: []string{"foo"}, setup: func() { wordRegexp = true }, line: "foo foo,foobar_foo", result: [][]int{{0, 3}, {4, 7}}},
+		{name: "regexp unicode words", patterns: []string{"кот"}, setup: func() { wordRegexp = true }, line: "котик кот", result: [][]int{{11, 17}}},
+		{name: "regexp word retries", patterns: []string{"ab|abc"}, setup: func() { wordRegexp = true }, line: "abc", result: [][]int{{0, 3}}},
+		{name: "regexp anchored word", patterns: []string{"^foo"}, setup: func() { wordRegexp = true }, line: "foofoo foo", result: nil},
+		{name: "fixed words", patterns: []string{"id1", "id12"}, setup: func() { fixed, wordRegexp = true, true }, line: "id123 id12 id1", result: [][]int{{6, 10}, {11, 14}}},
+		{name: "regexp line", patterns: []string{"a.c", "x"}, setup: func() { lineRegexp = true }, line: "abc", result: [][]int{{0, 3}}},
+		{name: "regexp line no match", patterns: []string{"b"}, setup: func() { lineRegexp = true }, line: "abc", result: nil},
+		{name: "fixed line", patterns: []string{"ABC", "b"}, setup: func() { fixed, lineRegexp, ignoreCase = true, true, true }, line: "abc", result: [][]int{{0, 3}}},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			resetFlags()
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+
+			m, err := newMatcher(testCase.patterns)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			result := m.find(testCase.line)
+			if !reflect.DeepEqual(result, testCase.result) {
+				t.Errorf("Incorrect spans: expect %v, got %v", testCase.result, result)
+			}
+			if m.match(testCase.line) != (testCase.result != nil) {
+				t.Errorf("Incorrect match: expect %v", testCase.result != nil)
+			}
+		})
+	}
+}
+
+func TestNewMatcherInvalidRegexp(t *testing.T) {
+	resetFlags()
+	if _, err := newMatcher([]string{"ok", "a("}); !errors.Is(err, errorInvalidRegexp) {
+		t.Errorf("Expected %v, got %v", errorInvalidRegexp, err)
+	}
+}
+
+func TestAhoCorasick(t *testing.T) {
+	patterns := []string{"he", "she", "his", "hers", "e"}
+	ac := newAhoCorasick(patterns, false)
+
+	var result [][]int
+	ac.scan("ushers", func(start, end int) bool {
+		result = append(result, []int{start, end})
+		return true
+	})
+
+	// every occurrence, naive search must agree
+	var expect [][]int
+	line := "ushers"
+	for end := 1; end <= len(line); end++ {
+		for _, pattern := range patterns {
+			if strings.HasSuffix(line[:end], pattern) {
+				expect = append(expect, []int{end - len(pattern), end})
+			}
+		}
+	}
+
+	sortSpans := func(spans [][]int) {
+		sort.Slice(spans, func(i, j int) bool {
+			if spans[i][1] != spans[j][1] {
+				return spans[i][1] < spans[j][1]
+			}
+			return spans[i][0] < spans[j][0]
+		})
+	}
+	sortSpans(result)
+	sortSpans(expect)
+	if !reflect.DeepEqual(result, expect) {
+		t.Errorf("Incorrect occurrences: expect %v, got %v", expect, result)
+	}
+}
+
+func TestCollectPatterns(t *testing.T) {
+	fileName := writeTestFile(t, "id1\nid2\n")
+
+	result, err := collectPatterns([]string{"a\nb"}, []string{fileName})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expect := []string{"a", "b", "id1", "id2"}; !reflect.DeepEqual(result, expect) {
+		t.Errorf("Incorrect patterns: expect %v, got %v", expect, result)
+	}
+
+	if _, err := collectPatterns(nil, []string{fileName + ".none"}); !errors.Is(err, errorFileNotFound) {
+		t.Errorf("Expected %v, got %v", errorFileNotFound, err)
+	}
+}
+
+func TestGrepJSON(t *testing.T) {
+	resetFlags()
+	jsonOutput, before = true, 1
+
+	builder := strings.Builder{}
+	stats, err := grep(&builder, "input", strings.NewReader("b\nxa ya\nc"), containsA)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expect := []string{
+		`{"type":"begin","data":{"path":{"text":"input"}}}`,
+		`{"type":"context","data":{"path":{"text":"input"},"lines":{"text":"b\n"},"line_number":1,"absolute_offset":0,"submatches":[]}}`,
+		`{"type":"match","data":{"path":{"text":"input"},"lines":{"text":"xa ya\n"},"line_number":2,"absolute_offset":2,"submatches":[{"match":{"text":"a"},"start":1,"end":2},{"match":{"text":"a"},"start":4,"end":5}]}}`,
+		`{"type":"end","data":{"path":{"text":"input"},"binary_offset":null,"stats":{"matched_lines":1,"matches":2,"bytes_searched":9}}}`,
+	}
+	if result := strings.Split(strings.TrimSuffix(builder.String(), "\n"), "\n"); !reflect.DeepEqual(result, expect) {
+		t.Errorf("Incorrect result:\nexpect %v\ngot    %v", strings.Join(expect, "\n"), strings.Join(result, "\n"))
+	}
+	if stats != (fileStats{selected: 1, matches: 2, bytes: 9}) {
+		t.Errorf("Incorrect stats: %+v", stats)
+	}
+}
+
+func TestGrepJSONNoMatch(t *testing.T) {
+	resetFlags()
+	jsonOutput = true
+
+	builder := strings.Builder{}
+	if _, err := grep(&builder, "input", strings.NewReader("b\n"), containsA); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if builder.Len() != 0 {
+		t.Errorf("Expected no events, got %q", builder.String())
+	}
+}
+
+func TestGrepJSONBinary(t *testing.T) {
+	resetFlags()
+	jsonOutput = true
+
+	builder := strings.Builder{}
+	if _, err := grep(&builder, "input", strings.NewReader("b\x00\na\n"), containsA); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(builder.String(), `"binary_offset":1,`) || strings.Contains(builder.String(), `"type":"match"`) {
+		t.Errorf("Incorrect result: %q", builder.String())
+	}
+}
+
+func TestGrepInputDecompress(t *testing.T) {
+	resetFlags()
+	decompress, lineNum = true, true
+
+	gz := bytes.Buffer{}
+	for _, member := range []string{"b\nxa\n", "c\nya\n"} {
+		zw := gzip.NewWriter(&gz)
+		zw.Write([]byte(member))
+		zw.Close()
+	}
+
+	testTable := []struct {
+		name   string
+		input  []byte
+		result string
+	}{
+		{name: "gzip with two members", input: gz.Bytes(), result: "2:xa\n4:ya\n"},
+		{
+			name:   "bzip2",
+			input:  []byte("\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\x42\x1a\x35\xdc\x00\x00\x02\x41\x80\x00\x10\x38\x00\x00\x40\x20\x00\x30\xcd\x00\xc3\x41\x4d\x9f\x17\x72\x45\x38\x50\x90\x42\x1a\x35\xdc"),
+			result: "2:xa\n",
+		},
+		{name: "plain", input: []byte("a\n"), result: "1:a\n"},
+		{name: "empty", input: nil, result: ""},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			builder := strings.Builder{}
+			if _, err := grepInput(&builder, "input", bytes.NewReader(testCase.input), containsA); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if builder.String() != testCase.result {
+				t.Errorf("Incorrect result: expect %q, got %q", testCase.result, builder.String())
+			}
+		})
+	}
+}
+
+func TestGrepInputCorrupted(t *testing.T) {
+	resetFlags()
+	decompress = true
+
+	builder := strings.Builder{}
+	if _, err := grepInput(&builder, "input", strings.NewReader("\x1f\x8bxx"), containsA); err == nil {
+		t.Error("Expected an error for a broken gzip header")
+	}
+	gz := bytes.Buffer{}
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(strings.Repeat("a line\n", 1000)))
+	zw.Close()
+	truncated := gz.Bytes()[:gz.Len()/2]
+	_, err := grepInput(&builder, "bad.gz", bytes.NewReader(truncated), containsA)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || !strings.HasPrefix(err.Error(), "bad.gz: ") {
+		t.Errorf("Expected %v of bad.gz, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
@@ -3,12 +3,18 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 /*
@@ -25,116 +31,700 @@ import (
 */
 
 var (
-	fields    string
-	delimiter string
-	separated bool
+	fields          string
+	bytesList       string
+	charsList       string
+	delimiter       string
+	outputDelimiter string
+	separated       bool
+	complement      bool
+	csvMode         bool
+	jsonlMode       bool
 
+	delimiterPattern string
+	delimiterRegexp  *regexp.Regexp
+	squeeze          bool
+
+	// set when the flag is given, an empty --output-delimiter is valid
+	delimiterSet       bool
+	outputDelimiterSet bool
+
+	mode      cutMode
 	fileNames []string
 )
 
 var (
-	errorFieldsFlag    = errors.New("The -f flag is required")
+	errorListFlag      = errors.New("One of -b, -c or -f is required")
+	errorManyLists     = errors.New("Only one type of list may be specified")
+	errorInvalidList   = errors.New("Invalid list")
+	errorDelimiterFlag = errors.New("-d, -D, -s and --squeeze may be specified only when operating on fields")
+	errorDelimiters    = errors.New("Only one of -d and -D may be specified")
+	errorCSVMode       = errors.New("--csv works only with -f, without -D and --squeeze")
+	errorCSVDelimiter  = errors.New("--csv delimiters must be a single character")
+	errorUnknownColumn = errors.New("No such column in the header")
+	errorJSONLMode     = errors.New("--jsonl works only with -f, without -D, --squeeze, --csv and --complement")
 	errorFileNotFound  = errors.New("No such file or directory")
 	errorInvalidRegexp = errors.New("Invalid regular expression")
-	errorNoFiles       = errors.New("File to cut was not specified")
+)
+
+// cutMode is what -b, -c and -f lists select.
+type cutMode int
+
+const (
+	modeFields cutMode = iota
+	modeBytes
+	modeChars
 )
 
 func init() {
-	flag.StringVar(&fields, "f", "", "select fields")
-	flag.StringVar(&delimiter, "d", "\t", "set delimiter")
-	flag.BoolVar(&separated, "s", false, "delimited lines only")
+	flag.StringVar(&fields, "f", "", "select only these fields")
+	flag.StringVar(&bytesList, "b", "", "select only these bytes")
+	flag.StringVar(&charsList, "c", "", "select only these characters")
+	flag.StringVar(&delimiter, "d", "\t", "use DELIM instead of TAB for field delimiter")
+	flag.StringVar(&delimiterPattern, "D", "", "split fields on matches of REGEX, the default output delimiter is a space")
+	flag.BoolVar(&squeeze, "squeeze", false, "treat runs of delimiters as one and ignore them at line ends, like awk")
+	flag.StringVar(&outputDelimiter, "output-delimiter", "", "use STRING as the output delimiter, the default is the input delimiter")
+	flag.BoolVar(&separated, "s", false, "do not print lines not containing delimiters")
+	flag.BoolVar(&complement, "complement", false, "complement the set of selected bytes, characters or fields")
+	flag.BoolVar(&csvMode, "csv", false, "parse RFC 4180 CSV, -f may name header columns, fields are printed in the order of -f")
+	flag.BoolVar(&jsonlMode, "jsonl", false, "parse lines as JSON objects, -f takes paths like user.id,items.0.name")
+}
 
+func main() {
 	flag.Parse()
 	fileNames = flag.Args()
-}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "d":
+			delimiterSet = true
+		case "output-delimiter":
+			outputDelimiterSet = true
+		}
+	})
 
-func main() {
-	if fields == "" {
-		fmt.Println(errorFieldsFlag)
-		return
+	list, err := selection()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-	if len(fileNames) == 0 {
-		fmt.Println(errorNoFiles)
-		return
+	w := bufio.NewWriter(os.Stdout)
+
+	cutter := func(r io.Reader) error {
+		return cut(w, r, list)
+	}
+	if csvMode {
+		spec, err := newCSVSpec()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		cutter = func(r io.Reader) error {
+			return cutCSV(w, r, spec)
+		}
+	}
+	if jsonlMode {
+		paths, err := parseJSONPaths(fields)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		cutter = func(r io.Reader) error {
+			return eachLine(w, r, func(line []byte) bool {
+				return cutJSON(w, line, paths)
+			})
+		}
 	}
 
-	flds, err := validateFields(fields)
-	if err != nil {
-		fmt.Println(err)
-		return
+	// without files, or with -, STDIN is read
+	if len(fileNames) == 0 {
+		fileNames = []string{"-"}
 	}
 
+	status := 0
 	for _, fileName := range fileNames {
-		input, err := os.Open(fileName)
-		if err != nil {
-			fmt.Printf("%s: %s\n", errorFileNotFound, fileName)
+		if err := cutFile(fileName, cutter); err != nil {
+			w.Flush()
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+		}
+	}
+
+	w.Flush()
+	os.Exit(status)
+}
+
+// selection sets mode by the only given list flag and parses the list,
+// with --csv and --jsonl the list is left to newCSVSpec and parseJSONPaths.
+func selection() (fieldList, error) {
+	lists := 0
+	for _, list := range []string{fields, bytesList, charsList} {
+		if list != "" {
+			lists++
 		}
+	}
+
+	switch {
+	case lists == 0:
+		return nil, errorListFlag
+	case lists > 1:
+		return nil, errorManyLists
+	case fields == "" && (delimiterSet || separated || delimiterPattern != "" || squeeze):
+		return nil, errorDelimiterFlag
+	case delimiterSet && delimiterPattern != "":
+		return nil, errorDelimiters
+	case csvMode && (fields == "" || delimiterPattern != "" || squeeze):
+		return nil, errorCSVMode
+	case jsonlMode && (fields == "" || delimiterPattern != "" || squeeze || csvMode || complement):
+		return nil, errorJSONLMode
+	case csvMode, jsonlMode:
+		return nil, nil
+	}
 
-		err = cut(input, flds)
+	if delimiterPattern != "" {
+		re, err := regexp.Compile(delimiterPattern)
 		if err != nil {
-			fmt.Println(err)
+			return nil, fmt.Errorf("%s: %w: %s", delimiterPattern, errorInvalidRegexp, err.Error())
 		}
-		input.Close()
+		delimiterRegexp = re
+	}
+
+	switch {
+	case bytesList != "":
+		mode = modeBytes
+		return parseList(bytesList)
+	case charsList != "":
+		mode = modeChars
+		return parseList(charsList)
 	}
+	mode = modeFields
+	return parseList(fields)
 }
 
-func validateFields(fields string) ([]int, error) {
-	var flds []int
+// fieldRange is an inclusive range of positions counted from 1,
+// hi is 0 when the range is open, like in 7-.
+type fieldRange struct {
+	lo, hi int
+}
 
-	for _, fld := range strings.Split(fields, ",") {
-		fld = strings.TrimSpace(fld)
+// fieldList is a sorted list of ranges that don't overlap or touch.
+type fieldList []fieldRange
 
-		v, err := strconv.Atoi(fld)
-		if err != nil {
-			return nil, fmt.Errorf("Error in validateFields - strconv.Atoi with -f %s: %s", fields, err.Error())
+// parseList parses POSIX lists like 1-3,5,7- and -2.
+func parseList(list string) (fieldList, error) {
+	ranges, err := parseRanges(list)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].lo < ranges[j].lo })
+
+	merged := ranges[:1]
+	for _, rg := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if last.hi != 0 && rg.lo > last.hi+1 {
+			merged = append(merged, rg)
+			continue
+		}
+		if last.hi != 0 && (rg.hi == 0 || rg.hi > last.hi) {
+			last.hi = rg.hi
 		}
-		flds = append(flds, v-1)
 	}
 
-	return flds, nil
+	return merged, nil
+}
+
+// parseRanges parses a list keeping ranges in the given order.
+func parseRanges(list string) ([]fieldRange, error) {
+	var ranges []fieldRange
+
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+
+		lo, hi, isRange := strings.Cut(part, "-")
+		if !isRange {
+			hi = lo
+		}
+		if lo == "" && hi == "" {
+			return nil, fmt.Errorf("%w: %q", errorInvalidList, list)
+		}
+
+		rg := fieldRange{lo: 1}
+		var err error
+		if lo != "" {
+			if rg.lo, err = parsePosition(lo); err != nil {
+				return nil, fmt.Errorf("%w: %q: %s", errorInvalidList, list, err.Error())
+			}
+		}
+		if hi != "" {
+			if rg.hi, err = parsePosition(hi); err != nil {
+				return nil, fmt.Errorf("%w: %q: %s", errorInvalidList, list, err.Error())
+			}
+			if rg.hi < rg.lo {
+				return nil, fmt.Errorf("%w: %q: decreasing range", errorInvalidList, list)
+			}
+		}
+		ranges = append(ranges, rg)
+	}
+
+	return ranges, nil
+}
+
+func parsePosition(s string) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if v < 1 {
+		return 0, errors.New("positions are numbered from 1")
+	}
+	return v, nil
+}
+
+// has reports whether position n, counted from 1, is in the list.
+func (l fieldList) has(n int) bool {
+	i := sort.Search(len(l), func(i int) bool { return l[i].hi == 0 || l[i].hi >= n })
+	return i < len(l) && l[i].lo <= n
+}
+
+// hasAny is has for a list in any order.
+func (l fieldList) hasAny(n int) bool {
+	for _, rg := range l {
+		if rg.lo <= n && (rg.hi == 0 || n <= rg.hi) {
+			return true
+		}
+	}
+	return false
+}
+
+// selected applies --complement to has.
+func (l fieldList) selected(n int) bool {
+	return l.has(n) != complement
+}
+
+// cutFile passes the file, or STDIN for -, to cutter.
+func cutFile(fileName string, cutter func(r io.Reader) error) error {
+	if fileName == "-" {
+		return cutter(os.Stdin)
+	}
+
+	input, err := os.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errorFileNotFound, fileName)
+	}
+	defer input.Close()
+
+	return cutter(input)
+}
+
+// cut writes selected parts of every line of r to w.
+func cut(w *bufio.Writer, r io.Reader, list fieldList) error {
+	return eachLine(w, r, func(line []byte) bool {
+		switch mode {
+		case modeBytes:
+			cutBytes(w, line, list)
+		case modeChars:
+			cutChars(w, line, list)
+		default:
+			return cutFields(w, line, list)
+		}
+		return true
+	})
+}
+
+// eachLine calls fn with every line of r without '\n' and ends the line in w
+// if fn reports it wrote one. Output goes out as soon as lines are cut, w is
+// flushed whenever r has nothing buffered, so a slow pipe isn't held back.
+// Lines may be of any length.
+func eachLine(w *bufio.Writer, r io.Reader, fn func(line []byte) bool) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+
+	var long []byte
+	for {
+		if br.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+
+		line, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// the line is longer than the buffer, collect it in long
+			long = append(long[:0], line...)
+			for err == bufio.ErrBufferFull {
+				line, err = br.ReadSlice('\n')
+				long = append(long, line...)
+			}
+			line = long
+		}
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("Error in eachLine - bufio.Reader.ReadSlice(): %w", err)
+		}
+		if len(line) == 0 && err == io.EOF {
+			return nil
+		}
+		line = bytes.TrimSuffix(line, []byte{'\n'})
+
+		if fn(line) {
+			w.WriteByte('\n')
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
 }
 
-func cut(file *os.File, flds []int) error {
-	buffer := bytes.Buffer{}
-	byteDel := []byte(delimiter)
+// cutFields writes selected fields of line joined by the output delimiter,
+// a line without delimiters is written as is or, with -s, not at all.
+// It reports whether anything was written.
+func cutFields(w *bufio.Writer, line []byte, list fieldList) bool {
+	words, found := splitFields(line)
+	if !found {
+		if !separated {
+			w.Write(line)
+		}
+		return !separated
+	}
 
-	scanner := bufio.NewScanner(file)
+	outDel := []byte(delimiter)
+	switch {
+	case outputDelimiterSet:
+		outDel = []byte(outputDelimiter)
+	case delimiterRegexp != nil:
+		outDel = []byte{' '}
+	}
 
-	firstLine := true
-	for scanner.Scan() {
-		line := scanner.Bytes()
+	first := true
+	for i, word := range words {
+		if !list.selected(i + 1) {
+			continue
+		}
+		if !first {
+			w.Write(outDel)
+		}
+		w.Write(word)
+		first = false
+	}
+	return true
+}
+
+// splitFields splits line by -d or by matches of -D, empty matches don't
+// split. found is false for a line without delimiters. With --squeeze empty
+// fields are dropped, so runs of delimiters count as one and delimiters at
+// the ends of the line are ignored.
+func splitFields(line []byte) (words [][]byte, found bool) {
+	if delimiterRegexp == nil {
+		words = bytes.Split(line, []byte(delimiter))
+	} else {
+		start := 0
+		for _, loc := range delimiterRegexp.FindAllIndex(line, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			words = append(words, line[start:loc[0]])
+			start = loc[1]
+		}
+		words = append(words, line[start:])
+	}
+	found = len(words) > 1
 
-		isContain := bytes.Contains(line, byteDel)
-		if separated && !isContain {
+	if squeeze {
+		kept := words[:0]
+		for _, word := range words {
+			if len(word) > 0 {
+				kept = append(kept, word)
+			}
+		}
+		words = kept
+	}
+	return words, found
+}
+
+// cutBytes writes selected bytes of line, with --output-delimiter it goes
+// between runs of adjacent bytes.
+func cutBytes(w *bufio.Writer, line []byte, list fieldList) {
+	last := 0
+	for i, b := range line {
+		if !list.selected(i + 1) {
 			continue
 		}
+		if outputDelimiterSet && last > 0 && last != i {
+			w.WriteString(outputDelimiter)
+		}
+		w.WriteByte(b)
+		last = i + 1
+	}
+}
 
-		if !firstLine {
-			buffer.WriteRune('\n')
+// cutChars is cutBytes for UTF-8 characters, an invalid byte counts as one.
+func cutChars(w *bufio.Writer, line []byte, list fieldList) {
+	last := 0
+	for n, i := 1, 0; i < len(line); n++ {
+		_, size := utf8.DecodeRune(line[i:])
+		if list.selected(n) {
+			if outputDelimiterSet && last > 0 && last != n-1 {
+				w.WriteString(outputDelimiter)
+			}
+			w.Write(line[i : i+size])
+			last = n
 		}
-		firstLine = false
+		i += size
+	}
+}
+
+//~~~~~~~~~~~~~~~~~~~
+
+// csvSpec selects CSV columns by positions or by header names, in the order
+// of -f, with --complement in the order of the input.
+type csvSpec struct {
+	ranges []fieldRange
+	names  []string
 
-		if !isContain {
-			buffer.Write(line)
+	comma, outComma rune
+}
+
+// newCSVSpec reads -f, -d and --output-delimiter, the delimiter is a comma
+// by default. A list that parses as positions is taken as positions.
+func newCSVSpec() (*csvSpec, error) {
+	spec := &csvSpec{}
+
+	ranges, err := parseRanges(fields)
+	if err == nil {
+		spec.ranges = ranges
+	} else {
+		for _, name := range strings.Split(fields, ",") {
+			if name == "" {
+				return nil, fmt.Errorf("%w: %q", errorInvalidList, fields)
+			}
+			spec.names = append(spec.names, name)
+		}
+	}
+
+	in := ","
+	if delimiterSet {
+		in = delimiter
+	}
+	out := in
+	if outputDelimiterSet {
+		out = outputDelimiter
+	}
+	if utf8.RuneCountInString(in) != 1 || utf8.RuneCountInString(out) != 1 {
+		return nil, errorCSVDelimiter
+	}
+	spec.comma, _ = utf8.DecodeRuneInString(in)
+	spec.outComma, _ = utf8.DecodeRuneInString(out)
+
+	return spec, nil
+}
+
+// resolve turns names into positions by the header of a file.
+func (s *csvSpec) resolve(header []string) ([]fieldRange, error) {
+	if s.names == nil {
+		return s.ranges, nil
+	}
+
+	ranges := make([]fieldRange, 0, len(s.names))
+	for _, name := range s.names {
+		i := 0
+		for i < len(header) && header[i] != name {
+			i++
+		}
+		if i == len(header) {
+			return nil, fmt.Errorf("%w: %s", errorUnknownColumn, name)
+		}
+		ranges = append(ranges, fieldRange{i + 1, i + 1})
+	}
+	return ranges, nil
+}
+
+// columns returns indexes of fields to print from a record of n fields,
+// an index past n stands for a missing field, which is printed empty, so
+// rows line up with the header of width fields.
+func columns(ranges []fieldRange, n, width int, indexes []int) []int {
+	indexes = indexes[:0]
+
+	if complement {
+		for i := 1; i <= n; i++ {
+			if !fieldList(ranges).hasAny(i) {
+				indexes = append(indexes, i-1)
+			}
+		}
+		return indexes
+	}
+
+	for _, rg := range ranges {
+		// an open range ends with the record, a bounded one may be
+		// anything up to MaxInt, only the header and the record bound it
+		hi := rg.hi
+		switch {
+		case hi == 0:
+			hi = n
+		case hi > rg.lo && hi > max(n, width):
+			hi = max(n, width)
+		}
+		for i := rg.lo; i <= hi; i++ {
+			indexes = append(indexes, i-1)
+		}
+	}
+	return indexes
+}
+
+// cutCSV is cut for RFC 4180 input, quoted fields may hold delimiters,
+// quotes and newlines. Output fields are quoted again where needed.
+func cutCSV(w *bufio.Writer, r io.Reader, spec *csvSpec) error {
+	// csv.NewReader keeps br, so br.Buffered tells if input is waiting
+	br := bufio.NewReaderSize(r, 64*1024)
+	cr := csv.NewReader(br)
+	cr.Comma = spec.comma
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	cw := csv.NewWriter(w)
+	cw.Comma = spec.outComma
+	defer cw.Flush()
+
+	var ranges []fieldRange
+	var indexes []int
+	var out []string
+	var width int
+	for first := true; ; first = false {
+		if br.Buffered() == 0 {
+			cw.Flush()
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Error in cutCSV - csv.Reader.Read(): %w", err)
+		}
+
+		if first {
+			if ranges, err = spec.resolve(record); err != nil {
+				return err
+			}
+			width = len(record)
+		}
+
+		if separated && len(record) == 1 {
 			continue
 		}
 
-		words := bytes.Split(line, byteDel)
+		out = out[:0]
+		indexes = columns(ranges, len(record), width, indexes)
+		for _, i := range indexes {
+			if i < len(record) {
+				out = append(out, record[i])
+			} else {
+				out = append(out, "")
+			}
+		}
+		if err := cw.Write(out); err != nil {
+			return err
+		}
+	}
+}
+
+//~~~~~~~~~~~~~~~~~~~
 
-		first := true
-		for _, fld := range flds {
-			if fld < len(words) {
-				if !first {
-					buffer.Write(byteDel)
-				}
-				buffer.Write(words[fld])
-				first = false
+// parseJSONPaths parses -f of --jsonl, paths are separated by commas and
+// their keys by dots, a number is also an index of an array.
+func parseJSONPaths(list string) ([][]string, error) {
+	var paths [][]string
+	for _, path := range strings.Split(list, ",") {
+		keys := strings.Split(strings.TrimSpace(path), ".")
+		for _, key := range keys {
+			if key == "" {
+				return nil, fmt.Errorf("%w: %q", errorInvalidList, list)
 			}
 		}
+		paths = append(paths, keys)
+	}
+	return paths, nil
+}
+
+// lookupJSON returns the raw value at path in a JSON object.
+func lookupJSON(object map[string]json.RawMessage, path []string) (json.RawMessage, bool) {
+	value, ok := object[path[0]]
+	for _, key := range path[1:] {
+		if !ok {
+			break
+		}
+		switch value = bytes.TrimSpace(value); {
+		case bytes.HasPrefix(value, []byte{'{'}):
+			var next map[string]json.RawMessage
+			if json.Unmarshal(value, &next) != nil {
+				return nil, false
+			}
+			value, ok = next[key]
+		case bytes.HasPrefix(value, []byte{'['}):
+			var next []json.RawMessage
+			i, err := strconv.Atoi(key)
+			if err != nil || json.Unmarshal(value, &next) != nil || i < 0 || i >= len(next) {
+				return nil, false
+			}
+			value = next[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, ok
+}
+
+// writeJSONValue writes strings unquoted and anything else as compact JSON.
+func writeJSONValue(w *bufio.Writer, value json.RawMessage) {
+	var s string
+	if bytes.HasPrefix(value, []byte{'"'}) && json.Unmarshal(value, &s) == nil {
+		w.WriteString(s)
+		return
+	}
+
+	compact := bytes.Buffer{}
+	if json.Compact(&compact, value) != nil {
+		w.Write(value)
+		return
+	}
+	w.Write(compact.Bytes())
+}
+
+// cutJSON writes values at paths of a JSON line joined by the output
+// delimiter. Missing values are empty, with -s the line is skipped. A line
+// that is not a JSON object is like a line without delimiters: written as
+// is, or with -s not at all. It reports whether anything was written.
+func cutJSON(w *bufio.Writer, line []byte, paths [][]string) bool {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(line, &object); err != nil || object == nil {
+		if !separated {
+			w.Write(line)
+		}
+		return !separated
 	}
 
-	fmt.Println(buffer.String())
+	values := make([]json.RawMessage, len(paths))
+	for i, path := range paths {
+		value, ok := lookupJSON(object, path)
+		if !ok && separated {
+			return false
+		}
+		values[i] = value
+	}
 
-	return nil
+	outDel := delimiter
+	if outputDelimiterSet {
+		outDel = outputDelimiter
+	}
+	for i, value := range values {
+		if i > 0 {
+			w.WriteString(outDel)
+		}
+		if value != nil {
+			writeJSONValue(w, value)
+		}
+	}
+	return true
 }
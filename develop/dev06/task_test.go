@@ -1 +1,425 @@
 package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func resetFlags() {
+	delimiter, outputDelimiter = "\t", ""
+	delimiterSet, outputDelimiterSet = false, false
+	separated, complement, csvMode, jsonlMode = false, false, false, false
+	delimiterPattern, delimiterRegexp, squeeze = "", nil, false
+	mode = modeFields
+}
+
+func TestParseList(t *testing.T) {
+	testTable := []struct {
+		list   string
+		result fieldList
+	}{
+		{list: "1", result: fieldList{{1, 1}}},
+		{list: "1-3,5,7-", result: fieldList{{1, 3}, {5, 5}, {7, 0}}},
+		{list: "-2", result: fieldList{{1, 2}}},
+		{list: "5,1,3-4", result: fieldList{{1, 1}, {3, 5}}},
+		{list: "2-4,3-6,9-,10", result: fieldList{{2, 6}, {9, 0}}},
+		{list: "3-,1-5", result: fieldList{{1, 0}}},
+	}
+
+	for _, testCase := range testTable {
+		result, err := parseList(testCase.list)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", testCase.list, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, testCase.result) {
+			t.Errorf("Incorrect result for %q: expect %v, got %v", testCase.list, testCase.result, result)
+		}
+	}
+}
+
+func TestParseListInvalid(t *testing.T) {
+	for _, list := range []string{"", "-", "0", "a", "3-1", "1,,2", "1-2-3"} {
+		if _, err := parseList(list); !errors.Is(err, errorInvalidList) {
+			t.Errorf("Expected %v for %q, got %v", errorInvalidList, list, err)
+		}
+	}
+}
+
+func TestCutLine(t *testing.T) {
+	testTable := []struct {
+		name   string
+		mode   cutMode
+		list   string
+		setup  func()
+		line   string
+		result string
+	}{
+		{name: "fields", list: "1,3-", line: "a\tb\tc\td", result: "a\tc\td"},
+		{name: "fields keep input order", list: "3,1", line: "a\tb\tc", result: "a\tc"},
+		{name: "fields out of line", list: "5-", line: "a\tb", result: ""},
+		{name: "no delimiter", list: "2", line: "abc", result: "abc"},
+		{name: "delimiter", list: "2", setup: func() { delimiter = "." }, line: "e.f.g", result: "f"},
+		{name: "output delimiter", list: "1-2", setup: func() { outputDelimiter, outputDelimiterSet = ",", true }, line: "a\tb\tc", result: "a,b"},
+		{name: "fields complement", list: "2", setup: func() { complement = true }, line: "a\tb\tc", result: "a\tc"},
+		{name: "bytes", mode: modeBytes, list: "1-2,4", line: "abcde", result: "abd"},
+		{name: "bytes split characters", mode: modeBytes, list: "1", line: "жук", result: "\xd0"},
+		{name: "bytes output delimiter", mode: modeBytes, list: "1-2,4", setup: func() { outputDelimiter, outputDelimiterSet = ":", true }, line: "abcde", result: "ab:d"},
+		{name: "chars", mode: modeChars, list: "2-", line: "жук", result: "ук"},
+		{name: "chars invalid byte", mode: modeChars, list: "2", line: "a\xffb", result: "\xff"},
+		{name: "chars complement", mode: modeChars, list: "-2", setup: func() { complement = true }, line: "привет", result: "ивет"},
+		{name: "chars output delimiter", mode: modeChars, list: "1,3", setup: func() { outputDelimiter, outputDelimiterSet = "|", true }, line: "жук", result: "ж|к"},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			resetFlags()
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			list, err := parseList(testCase.list)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			buffer := bytes.Buffer{}
+			w := bufio.NewWriter(&buffer)
+			switch testCase.mode {
+			case modeFields:
+				cutFields(w, []byte(testCase.line), list)
+			case modeBytes:
+				cutBytes(w, []byte(testCase.line), list)
+			case modeChars:
+				cutChars(w, []byte(testCase.line), list)
+			}
+			w.Flush()
+			if buffer.String() != testCase.result {
+				t.Errorf("Incorrect result: expect %q, got %q", testCase.result, buffer.String())
+			}
+		})
+	}
+}
+
+func TestSelection(t *testing.T) {
+	defer func() { fields, bytesList, charsList = "", "", "" }()
+
+	testTable := []struct {
+		name                         string
+		fields, bytesList, charsList string
+		setup                        func()
+		err                          error
+	}{
+		{name: "no list", err: errorListFlag},
+		{name: "two lists", fields: "1", bytesList: "1", err: errorManyLists},
+		{name: "delimiter with bytes", bytesList: "1", setup: func() { delimiterSet = true }, err: errorDelimiterFlag},
+		{name: "separated with chars", charsList: "1", setup: func() { separated = true }, err: errorDelimiterFlag},
+		{name: "squeeze with bytes", bytesList: "1", setup: func() { squeeze = true }, err: errorDelimiterFlag},
+		{name: "both delimiters", fields: "1", setup: func() { delimiterSet, delimiterPattern = true, " +" }, err: errorDelimiters},
+		{name: "invalid regexp", fields: "1", setup: func() { delimiterPattern = "(" }, err: errorInvalidRegexp},
+		{name: "csv with regexp", fields: "1", setup: func() { csvMode, delimiterPattern = true, " +" }, err: errorCSVMode},
+		{name: "jsonl with complement", fields: "a", setup: func() { jsonlMode, complement = true, true }, err: errorJSONLMode},
+		{name: "chars", charsList: "1"},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			resetFlags()
+			fields, bytesList, charsList = testCase.fields, testCase.bytesList, testCase.charsList
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			if _, err := selection(); !errors.Is(err, testCase.err) {
+				t.Errorf("Expected %v, got %v", testCase.err, err)
+			}
+		})
+	}
+	if mode != modeChars {
+		t.Errorf("Incorrect mode: expect %v, got %v", modeChars, mode)
+	}
+}
+
+func TestCut(t *testing.T) {
+	long := strings.Repeat("x", 200*1024)
+
+	testTable := []struct {
+		name   string
+		setup  func()
+		input  string
+		result string
+	}{
+		{name: "lines", input: "e.f.g\nj.k.l\nabcd\n", result: "f\nk\nabcd\n"},
+		{name: "no trailing newline", input: "e.f.g\nj.k.l", result: "f\nk\n"},
+		{name: "separated", setup: func() { separated = true }, input: "e.f.g\nabcd\n", result: "f\n"},
+		{name: "empty lines", input: "\n\na.b\n", result: "\n\nb\n"},
+		{name: "long line", input: "a." + long + ".c\nd.e\n", result: long + "\ne\n"},
+		{name: "empty input", input: "", result: ""},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			resetFlags()
+			delimiter = "."
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			list, _ := parseList("2")
+
+			buffer := bytes.Buffer{}
+			w := bufio.NewWriter(&buffer)
+			if err := cut(w, strings.NewReader(testCase.input), list); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			w.Flush()
+			if buffer.String() != testCase.result {
+				t.Errorf("Incorrect result: expect %q, got %q", testCase.result, buffer.String())
+			}
+		})
+	}
+}
+
+// slowReader hands out one line per Read and records the output seen by then.
+type slowReader struct {
+	lines  []string
+	output *bytes.Buffer
+	seen   []string
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	r.seen = append(r.seen, r.output.String())
+	if len(r.lines) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.lines[0])
+	r.lines = r.lines[1:]
+	return n, nil
+}
+
+func TestCutStreams(t *testing.T) {
+	resetFlags()
+	list, _ := parseList("1")
+
+	buffer := bytes.Buffer{}
+	r := &slowReader{lines: []string{"a\tb\n", "c\td\n"}, output: &buffer}
+	w := bufio.NewWriter(&buffer)
+	if err := cut(w, r, list); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the first line is out before the second one is read
+	if expect := []string{"", "a\n"}; !reflect.DeepEqual(r.seen[:2], expect) {
+		t.Errorf("Incorrect result: expect %q, got %q", expect, r.seen[:2])
+	}
+}
+
+func TestCutFileNotFound(t *testing.T) {
+	resetFlags()
+	list, _ := parseList("1")
+
+	fileName := filepath.Join(t.TempDir(), "missing.txt")
+	err := cutFile(fileName, func(r io.Reader) error {
+		return cut(bufio.NewWriter(io.Discard), r, list)
+	})
+	if !errors.Is(err, errorFileNotFound) {
+		t.Errorf("Expected %v, got %v", errorFileNotFound, err)
+	}
+}
+
+func TestCutCSV(t *testing.T) {
+	input := "user_id,name,date\n1,\"Smith, John\",2023-01-02\n2,\"say \"\"hi\"\"\",2023-01-03\n3,\"two\nlines\",2023-01-04\n"
+
+	testTable := []struct {
+		name   string
+		fields string
+		setup  func()
+		input  string
+		result string
+	}{
+		{name: "names in requested order", fields: "date,user_id", input: input, result: "date,user_id\n2023-01-02,1\n2023-01-03,2\n2023-01-04,3\n"},
+		{name: "quoted fields", fields: "name", input: input, result: "name\n\"Smith, John\"\n\"say \"\"hi\"\"\"\n\"two\nlines\"\n"},
+		{name: "positions in requested order", fields: "3,1", input: "a,b,c\n", result: "c,a\n"},
+		{name: "open range", fields: "2-", input: "a,b,c\nd,e\n", result: "b,c\ne\n"},
+		{name: "missing field is empty", fields: "1,3", input: "a,b,c\nd\n", result: "a,c\nd,\n"},
+		{name: "missing named field is empty", fields: "note,id", input: "id,name,note\n2,Bob\n", result: "note,id\n,2\n"},
+		{name: "range up to the header", fields: "2-3000000000", input: "a,b,c\nd\ne,f,g,h\n", result: "b,c\n,\nf,g,h\n"},
+		{name: "open range of a short record", fields: "2-", input: "a,b,c\nd\n", result: "b,c\n\n"},
+		{name: "complement", fields: "name", setup: func() { complement = true }, input: input[:45], result: "user_id,date\n1,2023-01-02\n"},
+		{name: "tsv to csv", fields: "2,1", setup: func() { delimiter, delimiterSet = "\t", true; outputDelimiter, outputDelimiterSet = ",", true }, input: "a\tb,c\n", result: "\"b,c\",a\n"},
+		{name: "separated", fields: "1", setup: func() { separated = true }, input: "a,b\nc\n", result: "a\n"},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			resetFlags()
+			csvMode, fields = true, testCase.fields
+			defer func() { fields = "" }()
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+
+			spec, err := newCSVSpec()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			buffer := bytes.Buffer{}
+			w := bufio.NewWriter(&buffer)
+			if err := cutCSV(w, strings.NewReader(testCase.input), spec); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			w.Flush()
+			if buffer.String() != testCase.result {
+				t.Errorf("Incorrect result: expect %q, got %q", testCase.result, buffer.String())
+			}
+		})
+	}
+}
+
+func TestCutCSVErrors(t *testing.T) {
+	defer func() { fields = "" }()
+
+	resetFlags()
+	csvMode, fields = true, "nope"
+	spec, err := newCSVSpec()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err = cutCSV(bufio.NewWriter(io.Discard), strings.NewReader("a,b\n"), spec)
+	if !errors.Is(err, errorUnknownColumn) {
+		t.Errorf("Expected %v, got %v", errorUnknownColumn, err)
+	}
+
+	fields = "1"
+	if spec, err = newCSVSpec(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err = cutCSV(bufio.NewWriter(io.Discard), strings.NewReader("a,\"b\n"), spec)
+	if err == nil {
+		t.Error("Expected an error for an unterminated quote")
+	}
+
+	delimiter, delimiterSet = "::", true
+	if _, err := newCSVSpec(); !errors.Is(err, errorCSVDelimiter) {
+		t.Errorf("Expected %v, got %v", errorCSVDelimiter, err)
+	}
+}
+
+func TestSplitFields(t *testing.T) {
+	testTable := []struct {
+		name   string
+		setup  func()
+		line   string
+		result []string
+		found  bool
+	}{
+		{name: "delimiter", setup: func() { delimiter = "::" }, line: "a::b:c", result: []string{"a", "b:c"}, found: true},
+		{name: "no delimiter", line: "abc", result: []string{"abc"}},
+		{name: "empty fields", setup: func() { delimiter = " " }, line: " a  b", result: []string{"", "a", "", "b"}, found: true},
+		{name: "squeeze", setup: func() { delimiter, squeeze = " ", true }, line: " a  b ", result: []string{"a", "b"}, found: true},
+		{name: "regexp", setup: func() { delimiterRegexp = regexp.MustCompile(`\s+`) }, line: "a \t b c", result: []string{"a", "b", "c"}, found: true},
+		{name: "regexp leading", setup: func() { delimiterRegexp = regexp.MustCompile(`\s+`) }, line: "  1 pts/0", result: []string{"", "1", "pts/0"}, found: true},
+		{name: "regexp squeeze", setup: func() { delimiterRegexp, squeeze = regexp.MustCompile(`\s+`), true }, line: "  1 pts/0 ", result: []string{"1", "pts/0"}, found: true},
+		{name: "regexp empty matches", setup: func() { delimiterRegexp = regexp.MustCompile(`,*`) }, line: "a,,b", result: []string{"a", "b"}, found: true},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			resetFlags()
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+
+			words, found := splitFields([]byte(testCase.line))
+			result := make([]string, 0, len(words))
+			for _, word := range words {
+				result = append(result, string(word))
+			}
+			if !reflect.DeepEqual(result, testCase.result) || found != testCase.found {
+				t.Errorf("Incorrect result: expect %q %v, got %q %v", testCase.result, testCase.found, result, found)
+			}
+		})
+	}
+}
+
+func TestCutFieldsRegexp(t *testing.T) {
+	resetFlags()
+	delimiterRegexp, squeeze = regexp.MustCompile(` +`), true
+	list, _ := parseList("1,4")
+
+	buffer := bytes.Buffer{}
+	w := bufio.NewWriter(&buffer)
+	for _, line := range []string{"  PID TTY          TIME CMD", "    1 pts/0    00:00:00 bash"} {
+		cutFields(w, []byte(line), list)
+		w.WriteByte('\n')
+	}
+	w.Flush()
+
+	if expect := "PID CMD\n1 bash\n"; buffer.String() != expect {
+		t.Errorf("Incorrect result: expect %q, got %q", expect, buffer.String())
+	}
+}
+
+func TestCutJSON(t *testing.T) {
+	line := `{"level":"info","user":{"id":42,"tags":["a","b"]},"event":{"date":"2023-01-02","at":null},"ok":true,"n":1.50}`
+
+	testTable := []struct {
+		name   string
+		fields string
+		setup  func()
+		line   string
+		result string
+	}{
+		{name: "nested paths", fields: "user.id,event.date", line: line, result: "42\t2023-01-02\n"},
+		{name: "array index", fields: "user.tags.1,level", line: line, result: "b\tinfo\n"},
+		{name: "raw values", fields: "ok,n,event.at,user.tags", line: line, result: "true\t1.50\tnull\t[\"a\",\"b\"]\n"},
+		{name: "output delimiter", fields: "level,user.id", setup: func() { outputDelimiter, outputDelimiterSet = ",", true }, line: line, result: "info,42\n"},
+		{name: "missing path is empty", fields: "user.name,level,user.tags.5", line: line, result: "\tinfo\t\n"},
+		{name: "missing path with -s", fields: "user.name,level", setup: func() { separated = true }, line: line, result: ""},
+		{name: "not an object", fields: "level", line: "panic: oops", result: "panic: oops\n"},
+		{name: "not an object with -s", fields: "level", setup: func() { separated = true }, line: "[1]", result: ""},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			resetFlags()
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			paths, err := parseJSONPaths(testCase.fields)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			buffer := bytes.Buffer{}
+			w := bufio.NewWriter(&buffer)
+			err = eachLine(w, strings.NewReader(testCase.line), func(line []byte) bool {
+				return cutJSON(w, line, paths)
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			w.Flush()
+			if buffer.String() != testCase.result {
+				t.Errorf("Incorrect result: expect %q, got %q", testCase.result, buffer.String())
+			}
+		})
+	}
+}
+
+func TestParseJSONPaths(t *testing.T) {
+	paths, err := parseJSONPaths("user.id, event.date")
+	if expect := [][]string{{"user", "id"}, {"event", "date"}}; err != nil || !reflect.DeepEqual(paths, expect) {
+		t.Errorf("Incorrect result: expect %q, got %q, %v", expect, paths, err)
+	}
+
+	for _, list := range []string{"", "a..b", "a,", ".a"} {
+		if _, err := parseJSONPaths(list); !errors.Is(err, errorInvalidList) {
+			t.Errorf("Expected %v for %q, got %v", errorInvalidList, list, err)
+		}
+	}
+}
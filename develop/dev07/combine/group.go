@@ -0,0 +1,110 @@
+package combine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrCanceled is the cause of a group closed by Cancel(nil).
+var ErrCanceled = errors.New("combine: group canceled")
+
+// TriggerError is the cause of a group closed by one of its own triggers.
+type TriggerError struct {
+	Group *DoneGroup
+	Index int
+}
+
+func (e *TriggerError) Error() string {
+	return fmt.Sprintf("combine: trigger %d of %s fired", e.Index, e.Group.Name())
+}
+
+// DoneGroup is a node of a cancellation tree. Its done channel closes when
+// one of its triggers fires, when it's canceled or when any ancestor closes,
+// children of a group wait on its done channel with Or, so a closed group
+// closes the whole subtree.
+//
+// Like a context, a group should be canceled once it's no longer needed, to
+// stop the goroutines waiting on its sources.
+type DoneGroup struct {
+	name string
+	done <-chan struct{}
+
+	cancel     chan struct{}
+	cancelOnce sync.Once
+	cancelErr  error
+
+	// cause is set before done closes
+	cause error
+}
+
+// NewDoneGroup returns a root group that closes when any of triggers fires.
+func NewDoneGroup(name string, triggers ...<-chan struct{}) *DoneGroup {
+	return newDoneGroup(nil, name, triggers)
+}
+
+// Child returns a group that closes with its own triggers or with g.
+func (g *DoneGroup) Child(name string, triggers ...<-chan struct{}) *DoneGroup {
+	return newDoneGroup(g, g.name+"/"+name, triggers)
+}
+
+func newDoneGroup(parent *DoneGroup, name string, triggers []<-chan struct{}) *DoneGroup {
+	g := &DoneGroup{
+		name:   name,
+		cancel: make(chan struct{}),
+	}
+
+	// sources are the parent, Cancel and the triggers, in this order
+	var parentDone <-chan struct{}
+	if parent != nil {
+		parentDone = parent.done
+	}
+	sources := append([]<-chan struct{}{parentDone, g.cancel}, triggers...)
+
+	g.done = watch(sources, func(i int) {
+		switch i {
+		case 0:
+			g.cause = parent.cause
+		case 1:
+			g.cause = g.cancelErr
+		default:
+			g.cause = &TriggerError{Group: g, Index: i - 2}
+		}
+	})
+
+	return g
+}
+
+// Name is the path of the group from the root, like pool/worker.
+func (g *DoneGroup) Name() string {
+	return g.name
+}
+
+// Done returns a channel that closes when the group does.
+func (g *DoneGroup) Done() <-chan struct{} {
+	return g.done
+}
+
+// Cancel closes the group and its subtree with err as the cause, nil is
+// ErrCanceled. It does nothing if the group is closed already.
+func (g *DoneGroup) Cancel(err error) {
+	if err == nil {
+		err = ErrCanceled
+	}
+	g.cancelOnce.Do(func() {
+		g.cancelErr = err
+		close(g.cancel)
+	})
+}
+
+// Cause returns the source that fired first: a *TriggerError, an error
+// given to Cancel, or the cause of the ancestor that closed first. It's nil
+// while the group is open.
+func (g *DoneGroup) Cause() error {
+	select {
+	case <-g.done:
+		return g.cause
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,124 @@
+package combine
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDoneGroupTrigger(t *testing.T) {
+	trigger := make(chan struct{})
+	root := NewDoneGroup("pool", make(chan struct{}), trigger)
+	worker := root.Child("worker")
+
+	if root.Cause() != nil || fired(worker.Done(), 10*time.Millisecond) {
+		t.Fatal("Expected open groups")
+	}
+	close(trigger)
+
+	if !fired(worker.Done(), wait) {
+		t.Fatal("Expected the child to close with its parent")
+	}
+	var cause *TriggerError
+	if !errors.As(worker.Cause(), &cause) || cause.Group != root || cause.Index != 1 {
+		t.Errorf("Incorrect cause: expect trigger 1 of pool, got %v", worker.Cause())
+	}
+	if expect := "combine: trigger 1 of pool fired"; worker.Cause().Error() != expect {
+		t.Errorf("Incorrect result: expect %q, got %q", expect, worker.Cause().Error())
+	}
+}
+
+func TestDoneGroupChildTrigger(t *testing.T) {
+	root := NewDoneGroup("pool")
+	defer root.Cancel(nil)
+
+	trigger := make(chan struct{})
+	worker := root.Child("worker", trigger)
+	task := worker.Child("task")
+	sibling := root.Child("sibling")
+	close(trigger)
+
+	if !fired(task.Done(), wait) {
+		t.Fatal("Expected the subtree to close")
+	}
+	if fired(root.Done(), 10*time.Millisecond) || fired(sibling.Done(), 0) {
+		t.Error("Expected the parent and the sibling to stay open")
+	}
+
+	var cause *TriggerError
+	if !errors.As(task.Cause(), &cause) || cause.Group != worker || cause.Index != 0 {
+		t.Errorf("Incorrect cause: expect trigger 0 of pool/worker, got %v", task.Cause())
+	}
+	if task.Name() != "pool/worker/task" {
+		t.Errorf("Incorrect name: expect %q, got %q", "pool/worker/task", task.Name())
+	}
+}
+
+func TestDoneGroupCancel(t *testing.T) {
+	errShutdown := errors.New("shutdown")
+
+	root := NewDoneGroup("pool")
+	task := root.Child("worker").Child("task")
+	root.Cancel(errShutdown)
+	root.Cancel(errors.New("ignored"))
+
+	if !fired(task.Done(), wait) {
+		t.Fatal("Expected the subtree to close")
+	}
+	if task.Cause() != errShutdown || root.Cause() != errShutdown {
+		t.Errorf("Incorrect cause: expect %v, got %v and %v", errShutdown, task.Cause(), root.Cause())
+	}
+
+	// a closed group keeps its first cause
+	task.Cancel(nil)
+	if task.Cause() != errShutdown {
+		t.Errorf("Incorrect cause: expect %v, got %v", errShutdown, task.Cause())
+	}
+
+	other := NewDoneGroup("other")
+	other.Cancel(nil)
+	<-other.Done()
+	if other.Cause() != ErrCanceled {
+		t.Errorf("Incorrect cause: expect %v, got %v", ErrCanceled, other.Cause())
+	}
+}
+
+func TestDoneGroupNoLeaks(t *testing.T) {
+	base := runtime.NumGoroutine()
+
+	root := NewDoneGroup("pool", make(chan struct{}))
+	for i := 0; i < 10; i++ {
+		worker := root.Child("worker")
+		for j := 0; j < 10; j++ {
+			worker.Child("task", make(chan struct{}))
+		}
+	}
+	root.Cancel(nil)
+	<-root.Done()
+
+	if !settled(base) {
+		t.Errorf("Incorrect goroutines: expect %d, got %d", base, runtime.NumGoroutine())
+	}
+}
+
+func TestDoneGroupConcurrent(t *testing.T) {
+	root := NewDoneGroup("pool")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			child := root.Child("worker")
+			go child.Cancel(nil)
+			go root.Cancel(nil)
+			<-child.Done()
+			if child.Cause() != ErrCanceled {
+				t.Errorf("Incorrect cause: expect %v, got %v", ErrCanceled, child.Cause())
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,161 @@
+// Package combine joins done channels: a channel fires when a receive from
+// it succeeds, that is when it's closed or a value is sent. Channels
+// returned by the package fire by closing, so any number of goroutines can
+// wait on them. Nil channels never fire.
+package combine
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// batch is how many inputs one helper goroutine of Or selects on.
+const batch = 8
+
+// Or returns a channel that closes as soon as any of chans fires. With no
+// channels there is nothing to wait for and the returned channel is closed.
+//
+// Every helper goroutine selects on a batch of inputs and on the result, so
+// all of them return as soon as any input fires.
+func Or[T any](chans ...<-chan T) <-chan T {
+	return watch(chans, nil)
+}
+
+// watch is Or that calls fired, if it's not nil, with the index of the
+// first input to fire right before the result closes.
+func watch[T any](chans []<-chan T, fired func(i int)) <-chan T {
+	done := make(chan T)
+	if len(chans) == 0 {
+		close(done)
+		return done
+	}
+
+	var once sync.Once
+	for start := 0; start < len(chans); start += batch {
+		// missing inputs of the last batch stay nil and never fire
+		var c [batch]<-chan T
+		copy(c[:], chans[start:])
+
+		go func(start int) {
+			var k int
+			select {
+			case <-c[0]:
+				k = 0
+			case <-c[1]:
+				k = 1
+			case <-c[2]:
+				k = 2
+			case <-c[3]:
+				k = 3
+			case <-c[4]:
+				k = 4
+			case <-c[5]:
+				k = 5
+			case <-c[6]:
+				k = 6
+			case <-c[7]:
+				k = 7
+			case <-done:
+				return
+			}
+			once.Do(func() {
+				if fired != nil {
+					fired(start + k)
+				}
+				close(done)
+			})
+		}(start)
+	}
+
+	return done
+}
+
+// maxCases is the limit of cases of reflect.Select.
+const maxCases = 65536
+
+// OrSelect is Or that waits on all chans in one goroutine with
+// reflect.Select, more than maxCases-1 inputs are split between goroutines.
+func OrSelect[T any](chans ...<-chan T) <-chan T {
+	done := make(chan T)
+	if len(chans) == 0 {
+		close(done)
+		return done
+	}
+
+	var once sync.Once
+	for i := 0; i < len(chans); i += maxCases - 1 {
+		part := chans[i:]
+		if len(part) > maxCases-1 {
+			part = part[:maxCases-1]
+		}
+
+		// the last case is the result, it's only there to stop the others
+		cases := make([]reflect.SelectCase, len(part)+1)
+		for j, c := range part {
+			cases[j] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c)}
+		}
+		cases[len(part)] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)}
+
+		go func() {
+			if chosen, _, _ := reflect.Select(cases); chosen == len(cases)-1 {
+				return
+			}
+			once.Do(func() { close(done) })
+		}()
+	}
+
+	return done
+}
+
+// OrContext is Or that also closes when ctx is done. With no channels it
+// closes only when ctx is done, and never if ctx can't be canceled, such a
+// channel is returned with no goroutine waiting for it.
+func OrContext[T any](ctx context.Context, chans ...<-chan T) <-chan T {
+	done := make(chan T)
+	if len(chans) == 0 {
+		if ctx.Done() == nil {
+			return done
+		}
+		go func() {
+			<-ctx.Done()
+			close(done)
+		}()
+		return done
+	}
+
+	// stop is one more input of Or, closing it lets Or finish when ctx wins
+	stop := make(chan T)
+	first := Or(append(chans[:len(chans):len(chans)], stop)...)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(stop)
+		case <-first:
+		}
+		close(done)
+	}()
+
+	return done
+}
+
+// And returns a channel that closes when all of chans have fired, with no
+// channels it's closed. A single goroutine waits on the inputs in turn.
+func And[T any](chans ...<-chan T) <-chan T {
+	done := make(chan T)
+	if len(chans) == 0 {
+		close(done)
+		return done
+	}
+
+	chans = append([]<-chan T(nil), chans...)
+	go func() {
+		for _, c := range chans {
+			<-c
+		}
+		close(done)
+	}()
+
+	return done
+}
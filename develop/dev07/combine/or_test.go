@@ -0,0 +1,294 @@
+package combine
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// wait is how long a channel that should fire is waited for.
+const wait = time.Second
+
+func after(d time.Duration) <-chan struct{} {
+	c := make(chan struct{})
+	time.AfterFunc(d, func() { close(c) })
+	return c
+}
+
+func fired[T any](c <-chan T, d time.Duration) bool {
+	select {
+	case <-c:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+// impls are the implementations of Or tested alike.
+var impls = []struct {
+	name string
+	or   func(chans ...<-chan struct{}) <-chan struct{}
+}{
+	{name: "Or", or: Or[struct{}]},
+	{name: "OrSelect", or: OrSelect[struct{}]},
+}
+
+// settled waits until no more than n goroutines are left.
+func settled(n int) bool {
+	deadline := time.Now().Add(wait)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+func TestOr(t *testing.T) {
+	never := make(chan struct{})
+	closed := make(chan struct{})
+	close(closed)
+
+	testTable := []struct {
+		name  string
+		chans []<-chan struct{}
+		fires bool
+	}{
+		{name: "no channels", chans: nil, fires: true},
+		{name: "one closed", chans: []<-chan struct{}{closed}, fires: true},
+		{name: "one open", chans: []<-chan struct{}{never}, fires: false},
+		{name: "two", chans: []<-chan struct{}{never, closed}, fires: true},
+		{name: "many", chans: []<-chan struct{}{never, never, never, never, closed, never}, fires: true},
+		{name: "many open", chans: []<-chan struct{}{never, never, never, never, never}, fires: false},
+		{name: "last of batches", chans: append(make([]<-chan struct{}, 20), closed), fires: true},
+		{name: "nil never fires", chans: []<-chan struct{}{nil, nil, nil}, fires: false},
+		{name: "nil and closed", chans: []<-chan struct{}{nil, closed, nil}, fires: true},
+	}
+
+	for _, impl := range impls {
+		for _, testCase := range testTable {
+			t.Run(impl.name+" "+testCase.name, func(t *testing.T) {
+				d := wait
+				if !testCase.fires {
+					d = 20 * time.Millisecond
+				}
+				if result := fired(impl.or(testCase.chans...), d); result != testCase.fires {
+					t.Errorf("Incorrect result: expect %v, got %v", testCase.fires, result)
+				}
+			})
+		}
+	}
+}
+
+func TestOrNoLeaks(t *testing.T) {
+	for _, impl := range impls {
+		t.Run(impl.name, func(t *testing.T) {
+			base := runtime.NumGoroutine()
+
+			// the inputs stay open forever, like sig(2*time.Hour)
+			chans := make([]<-chan struct{}, 1000)
+			for i := range chans {
+				chans[i] = make(chan struct{})
+			}
+			trigger := make(chan struct{})
+			chans[500] = trigger
+
+			done := impl.or(chans...)
+			if runtime.NumGoroutine() == base {
+				t.Fatal("Expected helper goroutines to wait")
+			}
+			close(trigger)
+			<-done
+
+			if !settled(base) {
+				t.Errorf("Incorrect goroutines: expect %d, got %d", base, runtime.NumGoroutine())
+			}
+		})
+	}
+}
+
+func TestOrSelectManyCases(t *testing.T) {
+	base := runtime.NumGoroutine()
+
+	chans := make([]<-chan struct{}, maxCases+100)
+	for i := range chans {
+		chans[i] = make(chan struct{})
+	}
+	trigger := make(chan struct{})
+	chans[len(chans)-1] = trigger
+
+	done := OrSelect(chans...)
+	close(trigger)
+	if !fired(done, wait) {
+		t.Fatal("Expected an input past maxCases to fire")
+	}
+	if !settled(base) {
+		t.Errorf("Incorrect goroutines: expect %d, got %d", base, runtime.NumGoroutine())
+	}
+}
+
+func TestOrFirst(t *testing.T) {
+	start := time.Now()
+	<-Or(after(time.Hour), after(time.Minute), after(10*time.Millisecond), after(time.Hour))
+
+	if elapsed := time.Since(start); elapsed > wait {
+		t.Errorf("Incorrect result: expect about 10ms, got %v", elapsed)
+	}
+}
+
+func TestOrValue(t *testing.T) {
+	values := make(chan int, 1)
+	values <- 1
+	done := Or(make(chan int), values, make(chan int))
+
+	if !fired(done, wait) {
+		t.Fatal("Expected a sent value to fire")
+	}
+	// the result closes, so every receiver sees it
+	if _, ok := <-done; ok {
+		t.Error("Expected the result to be closed")
+	}
+}
+
+func TestOrKeepsArgs(t *testing.T) {
+	a, b, c := make(chan int), make(chan int), make(chan int)
+	chans := make([]<-chan int, 3, 10)
+	chans[0], chans[1], chans[2] = a, b, c
+	spare := chans[:4]
+	spare[3] = a
+
+	Or(chans...)
+	time.Sleep(10 * time.Millisecond)
+	if spare[3] != a {
+		t.Error("Expected Or to keep the array of its arguments intact")
+	}
+}
+
+func TestOrContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := OrContext(ctx, make(chan int), make(chan int))
+	if fired(done, 20*time.Millisecond) {
+		t.Fatal("Expected no fire before cancel")
+	}
+	cancel()
+	if !fired(done, wait) {
+		t.Error("Expected a fire after cancel")
+	}
+
+	values := make(chan int)
+	done = OrContext(context.Background(), values)
+	close(values)
+	if !fired(done, wait) {
+		t.Error("Expected a fire after a channel closed")
+	}
+}
+
+func TestOrContextNoChannels(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := OrContext[int](ctx)
+	if fired(done, 20*time.Millisecond) {
+		t.Fatal("Expected no fire before cancel")
+	}
+	cancel()
+	if !fired(done, wait) {
+		t.Error("Expected a fire after cancel")
+	}
+}
+
+func TestOrContextNoChannelsBackground(t *testing.T) {
+	base := runtime.NumGoroutine()
+
+	done := OrContext[int](context.Background())
+	if fired(done, 20*time.Millisecond) {
+		t.Error("Expected no fire without a way to cancel")
+	}
+	if n := runtime.NumGoroutine(); n != base {
+		t.Errorf("Incorrect goroutines: expect %d, got %d", base, n)
+	}
+}
+
+func TestOrContextNoLeaks(t *testing.T) {
+	base := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := OrContext(ctx, make(chan int), make(chan int), make(chan int))
+	cancel()
+	<-done
+	if !settled(base) {
+		t.Errorf("Incorrect goroutines after cancel: expect %d, got %d", base, runtime.NumGoroutine())
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	values := make(chan int)
+	done = OrContext(ctx, make(chan int), values)
+	close(values)
+	<-done
+	if !settled(base) {
+		t.Errorf("Incorrect goroutines after a fire: expect %d, got %d", base, runtime.NumGoroutine())
+	}
+}
+
+func TestAnd(t *testing.T) {
+	if !fired(And[int](), wait) {
+		t.Error("Expected And of no channels to be closed")
+	}
+
+	chans := make([]<-chan struct{}, 100)
+	closers := make([]chan struct{}, len(chans))
+	for i := range chans {
+		closers[i] = make(chan struct{})
+		chans[i] = closers[i]
+	}
+	done := And(chans...)
+
+	// closed concurrently and out of order
+	for i := len(closers) - 1; i > 0; i-- {
+		go close(closers[i])
+	}
+	if fired(done, 20*time.Millisecond) {
+		t.Fatal("Expected no fire while an input is open")
+	}
+	close(closers[0])
+	if !fired(done, wait) {
+		t.Error("Expected a fire when all inputs closed")
+	}
+}
+
+func newChans(n int) ([]<-chan struct{}, []chan struct{}) {
+	chans := make([]<-chan struct{}, n)
+	closers := make([]chan struct{}, n)
+	for i := range chans {
+		closers[i] = make(chan struct{})
+		chans[i] = closers[i]
+	}
+	return chans, closers
+}
+
+func BenchmarkOr10k(b *testing.B) {
+	for _, impl := range impls {
+		b.Run(impl.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				chans, closers := newChans(10000)
+				done := impl.or(chans...)
+				close(closers[len(closers)/2])
+				<-done
+			}
+		})
+	}
+}
+
+func BenchmarkAnd10k(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		chans, closers := newChans(10000)
+		done := And(chans...)
+		for _, c := range closers {
+			close(c)
+		}
+		<-done
+	}
+}
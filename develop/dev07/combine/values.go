@@ -0,0 +1,169 @@
+package combine
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Combinators of this file pass values on. They stop and close their
+// outputs when done fires, so goroutines don't outlive a reader that gave
+// up. A nil done never fires.
+
+// FanIn merges values of chans into one channel, which closes when all of
+// chans are closed.
+func FanIn[T any](done <-chan struct{}, chans ...<-chan T) <-chan T {
+	out := make(chan T)
+
+	var wg sync.WaitGroup
+	wg.Add(len(chans))
+	for _, c := range chans {
+		go func(c <-chan T) {
+			defer wg.Done()
+			for {
+				select {
+				case v, ok := <-c:
+					if !ok {
+						return
+					}
+					select {
+					case out <- v:
+					case <-done:
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}(c)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// FirstValue waits for the first value sent on any of chans and returns it
+// with the index of its channel. Closed channels are dropped, if all of them
+// close or done fires first, the index is -1 and ok is false. Only one value
+// is received, values of other channels stay unread.
+//
+// It waits in the calling goroutine with reflect.Select, so it takes no more
+// than 65535 channels.
+func FirstValue[T any](done <-chan struct{}, chans ...<-chan T) (value T, index int, ok bool) {
+	// cases with a zero Chan are ignored by reflect.Select, as are nil
+	// channels, which never send
+	cases := make([]reflect.SelectCase, len(chans)+1)
+	open := 0
+	for i, c := range chans {
+		cases[i].Dir = reflect.SelectRecv
+		if c != nil {
+			cases[i].Chan = reflect.ValueOf(c)
+			open++
+		}
+	}
+	cases[len(chans)].Dir = reflect.SelectRecv
+	if done != nil {
+		cases[len(chans)].Chan = reflect.ValueOf(done)
+	}
+
+	for open > 0 {
+		chosen, v, received := reflect.Select(cases)
+		if chosen == len(chans) {
+			break
+		}
+		if received {
+			// a nil interface value gives the zero T
+			value, _ = v.Interface().(T)
+			return value, chosen, true
+		}
+		cases[chosen].Chan = reflect.Value{}
+		open--
+	}
+
+	return value, -1, false
+}
+
+// Tee sends every value of in to both outputs before reading the next one,
+// the outputs close when in does.
+func Tee[T any](done <-chan struct{}, in <-chan T) (<-chan T, <-chan T) {
+	out1, out2 := make(chan T), make(chan T)
+
+	go func() {
+		defer close(out1)
+		defer close(out2)
+
+		for {
+			var v T
+			select {
+			case value, ok := <-in:
+				if !ok {
+					return
+				}
+				v = value
+			case <-done:
+				return
+			}
+
+			// a nil channel blocks, so each output gets v once
+			a, b := out1, out2
+			for i := 0; i < 2; i++ {
+				select {
+				case a <- v:
+					a = nil
+				case b <- v:
+					b = nil
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	return out1, out2
+}
+
+// Bridge reads channels from streams and passes on their values in order,
+// one channel to its end before the next. The output closes when streams
+// does.
+func Bridge[T any](done <-chan struct{}, streams <-chan (<-chan T)) <-chan T {
+	out := make(chan T)
+
+	go func() {
+		defer close(out)
+
+		for {
+			var stream <-chan T
+			select {
+			case s, ok := <-streams:
+				if !ok {
+					return
+				}
+				stream = s
+			case <-done:
+				return
+			}
+
+			for open := true; open; {
+				select {
+				case v, ok := <-stream:
+					if !ok {
+						open = false
+						break
+					}
+					select {
+					case out <- v:
+					case <-done:
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
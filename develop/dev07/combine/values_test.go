@@ -0,0 +1,238 @@
+package combine
+
+import (
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func send(values ...int) <-chan int {
+	c := make(chan int)
+	go func() {
+		defer close(c)
+		for _, v := range values {
+			c <- v
+		}
+	}()
+	return c
+}
+
+func collect(c <-chan int) []int {
+	var values []int
+	for v := range c {
+		values = append(values, v)
+	}
+	return values
+}
+
+func TestFanIn(t *testing.T) {
+	result := collect(FanIn(nil, send(1, 2), send(), send(3), send(4, 5, 6)))
+	sort.Ints(result)
+
+	if expect := []int{1, 2, 3, 4, 5, 6}; !reflect.DeepEqual(result, expect) {
+		t.Errorf("Incorrect result: expect %v, got %v", expect, result)
+	}
+
+	if result := collect(FanIn[int](nil)); result != nil {
+		t.Errorf("Incorrect result: expect %v, got %v", nil, result)
+	}
+}
+
+func TestFanInDone(t *testing.T) {
+	base := runtime.NumGoroutine()
+
+	done := make(chan struct{})
+	never := make(chan int)
+	out := FanIn(done, never, never, never)
+	close(done)
+
+	if !fired(out, wait) {
+		t.Fatal("Expected the output to close after done")
+	}
+	if !settled(base) {
+		t.Errorf("Incorrect goroutines: expect %d, got %d", base, runtime.NumGoroutine())
+	}
+}
+
+func TestFirstValue(t *testing.T) {
+	closed := make(chan int)
+	close(closed)
+	values := make(chan int, 1)
+	values <- 7
+	other := make(chan int, 1)
+
+	value, index, ok := FirstValue(nil, closed, nil, values, other)
+	if value != 7 || index != 2 || !ok {
+		t.Errorf("Incorrect result: expect 7 2 true, got %v %v %v", value, index, ok)
+	}
+
+	value, index, ok = FirstValue(nil, closed, closed)
+	if value != 0 || index != -1 || ok {
+		t.Errorf("Incorrect result: expect 0 -1 false, got %v %v %v", value, index, ok)
+	}
+
+	done := make(chan struct{})
+	close(done)
+	if _, index, ok := FirstValue(done, make(chan int)); index != -1 || ok {
+		t.Errorf("Incorrect result: expect -1 false, got %v %v", index, ok)
+	}
+}
+
+func TestFirstValueOneReceive(t *testing.T) {
+	chans := make([]<-chan int, 10)
+	buffers := make([]chan int, len(chans))
+	for i := range chans {
+		buffers[i] = make(chan int, 1)
+		buffers[i] <- i
+		chans[i] = buffers[i]
+	}
+
+	if _, _, ok := FirstValue(nil, chans...); !ok {
+		t.Fatal("Expected a value")
+	}
+	left := 0
+	for _, c := range buffers {
+		left += len(c)
+	}
+	if left != len(buffers)-1 {
+		t.Errorf("Incorrect values left: expect %d, got %d", len(buffers)-1, left)
+	}
+}
+
+func TestFirstValueNilInterface(t *testing.T) {
+	c := make(chan error, 1)
+	c <- nil
+
+	value, index, ok := FirstValue(nil, c)
+	if value != nil || index != 0 || !ok {
+		t.Errorf("Incorrect result: expect <nil> 0 true, got %v %v %v", value, index, ok)
+	}
+}
+
+func TestTee(t *testing.T) {
+	out1, out2 := Tee(nil, send(1, 2, 3))
+
+	var wg sync.WaitGroup
+	results := make([][]int, 2)
+	for i, out := range []<-chan int{out1, out2} {
+		wg.Add(1)
+		go func(i int, out <-chan int) {
+			defer wg.Done()
+			results[i] = collect(out)
+		}(i, out)
+	}
+	wg.Wait()
+
+	expect := []int{1, 2, 3}
+	if !reflect.DeepEqual(results[0], expect) || !reflect.DeepEqual(results[1], expect) {
+		t.Errorf("Incorrect result: expect %v twice, got %v", expect, results)
+	}
+}
+
+func TestTeeDone(t *testing.T) {
+	done := make(chan struct{})
+	out1, out2 := Tee(done, send(1, 2, 3))
+	<-out1
+	close(done)
+
+	if !fired(out2, wait) || !fired(out1, wait) {
+		t.Error("Expected outputs to close after done")
+	}
+}
+
+func TestBridge(t *testing.T) {
+	streams := make(chan (<-chan int))
+	go func() {
+		defer close(streams)
+		streams <- send(1, 2)
+		streams <- send()
+		streams <- send(3)
+	}()
+
+	result := collect(Bridge(nil, streams))
+	if expect := []int{1, 2, 3}; !reflect.DeepEqual(result, expect) {
+		t.Errorf("Incorrect result: expect %v, got %v", expect, result)
+	}
+}
+
+func TestBridgeDone(t *testing.T) {
+	done := make(chan struct{})
+	streams := make(chan (<-chan int), 1)
+	streams <- make(chan int)
+	out := Bridge(done, streams)
+
+	time.Sleep(10 * time.Millisecond)
+	close(done)
+	if !fired(out, wait) {
+		t.Error("Expected the output to close after done")
+	}
+}
+
+func BenchmarkFanIn10k(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		chans := make([]<-chan int, 10000)
+		for j := range chans {
+			c := make(chan int, 1)
+			c <- j
+			close(c)
+			chans[j] = c
+		}
+		for range FanIn(nil, chans...) {
+		}
+	}
+}
+
+func BenchmarkFirstValue10k(b *testing.B) {
+	chans := make([]<-chan int, 10000)
+	last := make(chan int, 1)
+	for j := range chans {
+		chans[j] = make(chan int)
+	}
+	chans[len(chans)-1] = last
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		last <- i
+		FirstValue(nil, chans...)
+	}
+}
+
+func BenchmarkTee10k(b *testing.B) {
+	values := make([]int, 10000)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		out1, out2 := Tee(nil, send(values...))
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range out2 {
+			}
+		}()
+		for range out1 {
+		}
+		wg.Wait()
+	}
+}
+
+func BenchmarkBridge10k(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		streams := make(chan (<-chan int), 10000)
+		for j := 0; j < cap(streams); j++ {
+			c := make(chan int, 1)
+			c <- j
+			close(c)
+			streams <- c
+		}
+		close(streams)
+		for range Bridge(nil, streams) {
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module develop/dev07
+
+go 1.20
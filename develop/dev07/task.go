@@ -5,6 +5,8 @@ import (
 	"log"
 	"runtime"
 	"time"
+
+	"develop/dev07/combine"
 )
 
 /*
@@ -40,38 +42,10 @@ start := time.Now()
 fmt.Printf(“fone after %v”, time.Since(start))
 */
 
-var or func(channels ...<-chan interface{}) <-chan interface{}
+// or is combine.Or, the package can be used in other programs.
+var or = combine.Or[interface{}]
 
 func main() {
-	or = func(channels ...<-chan interface{}) <-chan interface{} {
-		done := make(chan interface{})
-
-		numberOfChannels := len(channels)
-		if numberOfChannels == 0 {
-			return nil
-		} else if numberOfChannels == 1 {
-			return channels[0]
-		}
-
-		go func() {
-			if numberOfChannels == 2 {
-				select {
-				case <-channels[0]:
-				case <-channels[1]:
-				}
-			} else {
-				select {
-				case <-channels[0]:
-				case <-channels[1]:
-				case <-or(append(channels[2:], done)...):
-				}
-			}
-			close(done)
-		}()
-
-		return done
-	}
-
 	sig := func(after time.Duration) <-chan interface{} {
 		c := make(chan interface{})
 		go func() {
@@ -1 +1,195 @@
 package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"develop/dev07/combine"
+)
+
+// The benchmarks compare strategies of or at 2 to 100k inputs, run them with
+//
+//	go test -run - -bench Or -benchtime 10x
+//
+// ns/op and allocs/op cover building the or, the signal and the teardown.
+// Custom metrics:
+//   - signal-ns is the time from closing an input to the result closing,
+//     the last input is closed, the worst case of the recursive or
+//   - goroutines is the peak number of helper goroutines
+//   - teardown-ns is the time from the signal until all helpers returned
+
+// orRecursive is the original or of the task, each goroutine waits on two
+// inputs and on the or of the rest.
+func orRecursive(channels ...<-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+
+	numberOfChannels := len(channels)
+	if numberOfChannels == 0 {
+		return nil
+	} else if numberOfChannels == 1 {
+		return channels[0]
+	}
+
+	go func() {
+		if numberOfChannels == 2 {
+			select {
+			case <-channels[0]:
+			case <-channels[1]:
+			}
+		} else {
+			select {
+			case <-channels[0]:
+			case <-channels[1]:
+			case <-orRecursive(append(channels[2:], done)...):
+			}
+		}
+		close(done)
+	}()
+
+	return done
+}
+
+// orOnce starts a goroutine per input, the first to fire closes the result.
+func orOnce(channels ...<-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+
+	var once sync.Once
+	for _, c := range channels {
+		go func(c <-chan struct{}) {
+			select {
+			case <-c:
+				once.Do(func() { close(done) })
+			case <-done:
+			}
+		}(c)
+	}
+
+	return done
+}
+
+// orContext starts a goroutine per input that cancels a shared context.
+func orContext(channels ...<-chan struct{}) <-chan struct{} {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	watch := func(c <-chan struct{}) {
+		select {
+		case <-c:
+			cancel()
+		case <-ctx.Done():
+		}
+	}
+	for _, c := range channels {
+		go watch(c)
+	}
+
+	return ctx.Done()
+}
+
+var strategies = []struct {
+	name string
+	or   func(channels ...<-chan struct{}) <-chan struct{}
+}{
+	{name: "recursive", or: orRecursive},
+	{name: "batched", or: combine.Or[struct{}]},
+	{name: "reflect", or: combine.OrSelect[struct{}]},
+	{name: "once", or: orOnce},
+	{name: "context", or: orContext},
+}
+
+// settle yields until the number of goroutines stops changing, the recursive
+// or starts its goroutines one from another.
+func settle() int {
+	n := runtime.NumGoroutine()
+	for stable := 0; stable < 10; stable++ {
+		runtime.Gosched()
+		if m := runtime.NumGoroutine(); m != n {
+			n, stable = m, 0
+		}
+	}
+	return n
+}
+
+// waitGoroutines waits until no more than n goroutines are left.
+func waitGoroutines(n int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			return false
+		}
+		runtime.Gosched()
+	}
+	return true
+}
+
+func newInputs(n int) ([]<-chan struct{}, chan struct{}) {
+	chans := make([]<-chan struct{}, n)
+	for i := range chans {
+		chans[i] = make(chan struct{})
+	}
+	last := make(chan struct{})
+	chans[n-1] = last
+	return chans, last
+}
+
+func TestStrategies(t *testing.T) {
+	for _, strategy := range strategies {
+		for _, n := range []int{2, 3, 100} {
+			t.Run(fmt.Sprintf("%s %d", strategy.name, n), func(t *testing.T) {
+				base := runtime.NumGoroutine()
+				chans, last := newInputs(n)
+
+				done := strategy.or(chans...)
+				close(last)
+				select {
+				case <-done:
+				case <-time.After(time.Second):
+					t.Fatal("Expected the result to close")
+				}
+
+				if !waitGoroutines(base, time.Second) {
+					t.Errorf("Incorrect goroutines: expect %d, got %d", base, runtime.NumGoroutine())
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkOr(b *testing.B) {
+	for _, n := range []int{2, 10, 100, 1000, 10000, 100000} {
+		for _, strategy := range strategies {
+			b.Run(fmt.Sprintf("%s/%d", strategy.name, n), func(b *testing.B) {
+				b.ReportAllocs()
+
+				var signal, teardown time.Duration
+				peak := 0
+				for i := 0; i < b.N; i++ {
+					base := runtime.NumGoroutine()
+					chans, last := newInputs(n)
+
+					done := strategy.or(chans...)
+					if helpers := settle() - base; helpers > peak {
+						peak = helpers
+					}
+
+					start := time.Now()
+					close(last)
+					<-done
+					signal += time.Since(start)
+
+					if !waitGoroutines(base, time.Minute) {
+						b.Fatalf("Incorrect goroutines: expect %d, got %d", base, runtime.NumGoroutine())
+					}
+					teardown += time.Since(start)
+				}
+
+				b.ReportMetric(float64(signal.Nanoseconds())/float64(b.N), "signal-ns")
+				b.ReportMetric(float64(teardown.Nanoseconds())/float64(b.N), "teardown-ns")
+				b.ReportMetric(float64(peak), "goroutines")
+			})
+		}
+	}
+}
@@ -2,13 +2,18 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 )
 
 /*
@@ -27,18 +32,39 @@ import (
 
 var (
 	userHomeDir string
+
+	// exitStatus is the status of the last command, $? expands to it
+	exitStatus int
 )
 
 var (
-	errorShellParse = errors.New("shell: parse error near `|'")
-	errorUnknownCmd = errors.New("shell: command not found")
-	errorChangeDir  = errors.New("cd: string not in pwd")
-	errorIllegalPID = errors.New("kill: illegal pid")
+	errorShellParse    = errors.New("shell: parse error near `|'")
+	errorUnknownCmd    = errors.New("shell: command not found")
+	errorNotExecutable = errors.New("shell: permission denied")
+	errorChangeDir     = errors.New("cd: string not in pwd")
+	errorIllegalPID    = errors.New("kill: illegal pid")
+)
+
+// exit statuses as in sh
+const (
+	statusFailure       = 1
+	statusParseError    = 2
+	statusNotExecutable = 126
+	statusNotFound      = 127
 )
 
 type command struct {
 	name string
 	args []string
+	// path is the executable found in PATH, empty for builtins
+	path string
+
+	// stdin and stdout are the terminal or ends of pipes to the neighbours
+	// in a pipeline
+	stdin, stdout *os.File
+	// piped is set for a stage of a pipeline of two or more commands, it
+	// runs as in a subshell of sh and can't change the shell's state
+	piped bool
 }
 
 func init() {
@@ -52,16 +78,16 @@ func init() {
 
 func main() {
 	scanner := bufio.NewScanner(os.Stdin)
-	for {
-		scanner.Scan()
+	for scanner.Scan() {
 		run(scanner.Bytes())
 	}
+	os.Exit(exitStatus)
 }
 
 func validateAndBatch(cmdsBytes []byte) ([]command, error) {
 	_cmds := strings.Split(string(cmdsBytes), " | ")
 
-	availableCmds := map[string]struct{}{
+	builtinCmds := map[string]struct{}{
 		"cd":     {},
 		"pwd":    {},
 		"echo":   {},
@@ -76,42 +102,129 @@ func validateAndBatch(cmdsBytes []byte) ([]command, error) {
 		if len(words) == 0 {
 			return nil, errorShellParse
 		}
-
-		if _, ok := availableCmds[words[0]]; !ok {
-			return nil, fmt.Errorf("%w: %s", errorUnknownCmd, words[0])
+		for j, word := range words {
+			words[j] = strings.ReplaceAll(word, "$?", strconv.Itoa(exitStatus))
 		}
 
 		cmds[i] = command{
 			name: words[0],
 			args: words[1:],
 		}
+
+		if _, ok := builtinCmds[words[0]]; ok {
+			continue
+		}
+
+		// anything else is a program, looked up in PATH unless it has a '/'
+		path, err := exec.LookPath(words[0])
+		switch {
+		case errors.Is(err, fs.ErrPermission):
+			return nil, fmt.Errorf("%w: %s", errorNotExecutable, words[0])
+		case err != nil:
+			return nil, fmt.Errorf("%w: %s", errorUnknownCmd, words[0])
+		}
+		cmds[i].path = path
 	}
 
 	return cmds, nil
 }
 
 func run(cmdsBytes []byte) {
+	if len(bytes.TrimSpace(cmdsBytes)) == 0 {
+		return
+	}
+
 	cmds, err := validateAndBatch(cmdsBytes)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
+		switch {
+		case errors.Is(err, errorUnknownCmd):
+			exitStatus = statusNotFound
+		case errors.Is(err, errorNotExecutable):
+			exitStatus = statusNotExecutable
+		default:
+			exitStatus = statusParseError
+		}
 		return
 	}
 
-	for _, cmd := range cmds {
-		switch cmd.name {
-		case "cd":
-			cmd.changeDirectory()
-		case "pwd":
-			cmd.presentWorkingDirectory()
-		case "echo":
-			cmd.echo()
-		case "kill":
-			cmd.kill()
-		case "ps":
-			cmd.processStatus()
-		case "\\quit":
-			os.Exit(0)
+	exitStatus = pipeline(cmds)
+}
+
+// pipeline runs all cmds at once, each reading what the one before writes,
+// and returns the status of the last one as sh does.
+func pipeline(cmds []command) int {
+	cmds[0].stdin = os.Stdin
+	cmds[len(cmds)-1].stdout = os.Stdout
+	for i := 0; i+1 < len(cmds); i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "shell: %s\n", err.Error())
+			for _, cmd := range cmds[:i+1] {
+				cmd.closePipes()
+			}
+			return statusFailure
 		}
+		cmds[i].stdout, cmds[i+1].stdin = w, r
+		cmds[i].piped, cmds[i+1].piped = true, true
+	}
+
+	// Ctrl-C interrupts the programs, not the shell
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	statuses := make([]int, len(cmds))
+	var wg sync.WaitGroup
+	for i := range cmds {
+		wg.Add(1)
+		go func(cmd *command, status *int) {
+			defer wg.Done()
+			*status = cmd.runStage()
+		}(&cmds[i], &statuses[i])
+	}
+	wg.Wait()
+
+	return statuses[len(statuses)-1]
+}
+
+// runStage runs c as a stage of a pipeline. Its ends of pipes are closed
+// when it's done, so the next stage reads EOF and the one before stops
+// on a closed pipe.
+func (c *command) runStage() int {
+	if c.path != "" {
+		return c.execute()
+	}
+	defer c.closePipes()
+
+	switch c.name {
+	case "cd":
+		return c.changeDirectory()
+	case "pwd":
+		return c.presentWorkingDirectory()
+	case "echo":
+		return c.echo()
+	case "kill":
+		return c.kill()
+	case "ps":
+		return c.processStatus()
+	case "\\quit":
+		// only the subshell of the stage would exit
+		if c.piped {
+			return 0
+		}
+		os.Exit(0)
+	}
+	return statusNotFound
+}
+
+// closePipes closes the ends of pipes c was given, the terminal is kept.
+func (c *command) closePipes() {
+	if c.stdin != nil && c.stdin != os.Stdin {
+		c.stdin.Close()
+	}
+	if c.stdout != nil && c.stdout != os.Stdout {
+		c.stdout.Close()
 	}
 }
 
@@ -119,75 +232,130 @@ func getCurrDir() (string, error) {
 	return os.Getwd()
 }
 
-func (c *command) changeDirectory() {
+func (c *command) changeDirectory() int {
 	if len(c.args) > 1 {
 		fmt.Fprintf(os.Stderr, "%s: %s\n", errorChangeDir.Error(), c.args[0])
-		return
+		return statusFailure
 	}
 
 	path := userHomeDir
 	if len(c.args) == 1 {
 		if c.args[0][0] == '/' {
-			path = c.args[1]
+			path = c.args[0]
 		} else {
 			var err error
 			path, err = getCurrDir()
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%s: %s\n", c.name, err.Error())
-				return
+				return statusFailure
 			}
 			path = filepath.Join(path, c.args[0])
 		}
 	}
 
-	if err := os.Chdir(path); err != nil {
+	// other stages run at the same time, the shell's directory stays
+	chdir := os.Chdir
+	if c.piped {
+		chdir = checkDirectory
+	}
+	if err := chdir(path); err != nil {
 		fmt.Fprintf(os.Stderr, "%s: %s\n", c.name, err.Error())
+		return statusFailure
+	}
+	return 0
+}
+
+// checkDirectory fails like os.Chdir would, without changing directory.
+func checkDirectory(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return &fs.PathError{Op: "chdir", Path: path, Err: errors.Unwrap(err)}
+	}
+	if !info.IsDir() {
+		return &fs.PathError{Op: "chdir", Path: path, Err: syscall.ENOTDIR}
 	}
+	return nil
 }
 
-func (c *command) presentWorkingDirectory() {
+func (c *command) presentWorkingDirectory() int {
 	path, err := getCurrDir()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s: %s\n", c.name, err.Error())
-		return
+		return statusFailure
 	}
-	fmt.Fprintln(os.Stdout, path)
+	fmt.Fprintln(c.stdout, path)
+	return 0
 }
 
-func (c *command) echo() {
-	fmt.Fprintln(os.Stdout, strings.Join(c.args, " "))
+func (c *command) echo() int {
+	fmt.Fprintln(c.stdout, strings.Join(c.args, " "))
+	return 0
 }
 
-func (c *command) kill() {
+func (c *command) kill() int {
 	pids := make([]int, len(c.args))
 	for i, arg := range c.args {
 		pid, err := strconv.Atoi(arg)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s: %s\n", errorIllegalPID.Error(), arg)
-			return
+			return statusFailure
 		}
 		pids[i] = pid
 	}
 
+	status := 0
 	for _, pid := range pids {
 		proc, err := os.FindProcess(pid)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s: %s\n", c.name, err.Error())
+		if err == nil {
+			err = proc.Kill()
 		}
-		if err = proc.Kill(); err != nil {
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s: %s\n", c.name, err.Error())
+			status = statusFailure
 		}
 	}
+	return status
 }
 
-func (c *command) processStatus() {
+func (c *command) processStatus() int {
 	cmd := exec.Command(c.name)
 
 	stdout, err := cmd.Output()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s: %s\n", c.name, err.Error())
-		return
+		return statusFailure
 	}
 
-	fmt.Fprint(os.Stdout, string(stdout))
+	fmt.Fprint(c.stdout, string(stdout))
+	return 0
+}
+
+// execute runs a program with c.stdin, c.stdout and the terminal's stderr
+// and returns its exit status, 128+N if it was killed by signal N.
+func (c *command) execute() int {
+	cmd := exec.Command(c.path, c.args...)
+	cmd.Args[0] = c.name
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = c.stdin, c.stdout, os.Stderr
+
+	// the program has its own copies of the pipes now, the shell's ones
+	// would keep the neighbours from seeing EOF or a closed pipe
+	err := cmd.Start()
+	c.closePipes()
+	if err == nil {
+		err = cmd.Wait()
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	default:
+		fmt.Fprintf(os.Stderr, "%s: %s\n", c.name, err.Error())
+		return statusNotExecutable
+	}
 }
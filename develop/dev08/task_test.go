@@ -1 +1,186 @@
 package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateAndBatch(t *testing.T) {
+	testTable := []struct {
+		line string
+		err  error
+	}{
+		{line: "echo a | pwd", err: nil},
+		{line: "true", err: nil},
+		{line: "ls -l | true", err: nil},
+		{line: "no-such-command-here", err: errorUnknownCmd},
+		{line: "echo a | ", err: errorShellParse},
+	}
+
+	for _, testCase := range testTable {
+		_, err := validateAndBatch([]byte(testCase.line))
+		if !errors.Is(err, testCase.err) {
+			t.Errorf("Incorrect result for %q: expect %v, got %v", testCase.line, testCase.err, err)
+		}
+	}
+}
+
+func TestExitStatusExpansion(t *testing.T) {
+	exitStatus = 3
+	defer func() { exitStatus = 0 }()
+
+	cmds, err := validateAndBatch([]byte("echo $? x$?"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := cmds[0].args; len(got) != 2 || got[0] != "3" || got[1] != "x3" {
+		t.Errorf("Incorrect result: expect %v, got %v", []string{"3", "x3"}, got)
+	}
+}
+
+func TestRunExitStatus(t *testing.T) {
+	defer func() { exitStatus = 0 }()
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "exit3")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nexit 3\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	noexec := filepath.Join(dir, "noexec")
+	if err := os.WriteFile(noexec, []byte("#!/bin/sh\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	testTable := []struct {
+		line   string
+		status int
+	}{
+		{line: "true", status: 0},
+		{line: "false", status: statusFailure},
+		{line: script, status: 3},
+		{line: noexec, status: statusNotExecutable},
+		{line: "no-such-command-here", status: statusNotFound},
+		{line: "echo a | ", status: statusParseError},
+		{line: "cd /no/such/dir", status: statusFailure},
+		{line: "false | true", status: 0},
+	}
+
+	for _, testCase := range testTable {
+		run([]byte(testCase.line))
+		if exitStatus != testCase.status {
+			t.Errorf("Incorrect result for %q: expect %v, got %v", testCase.line, testCase.status, exitStatus)
+		}
+	}
+}
+
+// captureStdout runs fn with os.Stdout going to a pipe, and os.Stdin reading
+// input, and returns what was written.
+func captureStdout(t *testing.T, input string, fn func()) string {
+	t.Helper()
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		io.WriteString(inW, input)
+		inW.Close()
+	}()
+
+	stdin, stdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = inR, outW
+	defer func() { os.Stdin, os.Stdout = stdin, stdout }()
+
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(outR)
+		out <- string(b)
+	}()
+
+	fn()
+	outW.Close()
+	inR.Close()
+	return <-out
+}
+
+func TestPipeline(t *testing.T) {
+	defer func() { exitStatus = 0 }()
+
+	testTable := []struct {
+		line   string
+		output string
+		status int
+	}{
+		{line: "echo a b c | wc -w", output: "3\n", status: 0},
+		{line: "echo a | cat | cat", output: "a\n", status: 0},
+		{line: "cat", output: "shell input\n", status: 0},
+		{line: "echo a | cat", output: "a\n", status: 0},
+		{line: "yes | head -n 2", output: "y\ny\n", status: 0},
+		{line: "cat | echo b", output: "b\n", status: 0},
+		{line: "echo a | false", output: "", status: statusFailure},
+		{line: "false | echo a", output: "a\n", status: 0},
+		{line: "pwd | wc -l", output: "1\n", status: 0},
+	}
+
+	for _, testCase := range testTable {
+		output := captureStdout(t, "shell input\n", func() { run([]byte(testCase.line)) })
+		if output != testCase.output {
+			t.Errorf("Incorrect result for %q: expect %q, got %q", testCase.line, testCase.output, output)
+		}
+		if exitStatus != testCase.status {
+			t.Errorf("Incorrect status for %q: expect %v, got %v", testCase.line, testCase.status, exitStatus)
+		}
+	}
+}
+
+func TestPipelineKeepsShellState(t *testing.T) {
+	defer func() { exitStatus = 0 }()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	testTable := []struct {
+		line   string
+		output string
+		status int
+	}{
+		{line: "cd / | pwd", output: wd + "\n", status: 0},
+		{line: "pwd | cd /", output: "", status: 0},
+		{line: "echo a | cd /no/such/dir", output: "", status: statusFailure},
+		{line: "echo a | cd " + file, output: "", status: statusFailure},
+		{line: "\\quit | echo a", output: "a\n", status: 0},
+	}
+
+	for _, testCase := range testTable {
+		output := captureStdout(t, "", func() { run([]byte(testCase.line)) })
+		if output != testCase.output {
+			t.Errorf("Incorrect result for %q: expect %q, got %q", testCase.line, testCase.output, output)
+		}
+		if exitStatus != testCase.status {
+			t.Errorf("Incorrect status for %q: expect %v, got %v", testCase.line, testCase.status, exitStatus)
+		}
+		if dir, _ := os.Getwd(); dir != wd {
+			t.Fatalf("Incorrect directory after %q: expect %s, got %s", testCase.line, wd, dir)
+		}
+	}
+
+	// a single cd still changes the shell's directory
+	defer os.Chdir(wd)
+	dir := t.TempDir()
+	run([]byte("cd " + dir))
+	if got, _ := os.Getwd(); exitStatus != 0 || got != dir {
+		t.Errorf("Incorrect directory: expect %s, got %s", dir, got)
+	}
+}